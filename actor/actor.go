@@ -0,0 +1,67 @@
+package actor
+
+import (
+	"context"
+	"image"
+
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// PipelineKey lists names of actors processing a picture in order, between
+// downloading and setting it as wallpaper
+const PipelineKey = "pipeline"
+
+// Actor processes a downloaded picture, e.g. crops it or renders
+// watermarks. Settings of the channel, merged with the global ones, are
+// available from the context by Setting
+type Actor interface {
+	Process(ctx context.Context, im image.Image, meta *channel.PictureMeta) (image.Image, error)
+}
+
+// Actors singleton for convenience
+var Actors = util.RegistryMap{}
+
+type settingKey struct{}
+
+// WithSetting makes the channel setting available to actors
+func WithSetting(ctx context.Context, setting *viper.Viper) context.Context {
+	return context.WithValue(ctx, settingKey{}, setting)
+}
+
+// Setting returns the channel setting of the context, or the global one if
+// there is none
+func Setting(ctx context.Context) *viper.Viper {
+	if setting, ok := ctx.Value(settingKey{}).(*viper.Viper); ok && setting != nil {
+		return setting
+	}
+	return util.Settings.Copy()
+}
+
+// RunPipeline passes the picture through actors listed in the pipeline of
+// the context setting. An actor failing or not registered is skipped with a
+// warning, so the picture is still usable. Only cancellation of the context
+// fails the pipeline
+func RunPipeline(ctx context.Context, im image.Image, meta *channel.PictureMeta) (image.Image, error) {
+	for _, name := range Setting(ctx).GetStringSlice(PipelineKey) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		l := logrus.WithField("actor", name)
+		v, ok := Actors.Get(name)
+		if !ok {
+			l.Warn("unknown actor in pipeline, skipped")
+			continue
+		}
+		processed, err := v.(Actor).Process(ctx, im, meta)
+		if err != nil {
+			l.WithError(err).Warn("actor failed, skipped")
+			continue
+		}
+		l.Debug("picture processed")
+		im = processed
+	}
+	return im, nil
+}
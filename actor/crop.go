@@ -1,10 +1,12 @@
 package actor
 
 import (
+	"context"
 	"image"
 	"image/draw"
 	"runtime"
 
+	"github.com/genzj/goTApaper/channel"
 	"github.com/genzj/goTApaper/util"
 
 	"github.com/sirupsen/logrus"
@@ -14,9 +16,10 @@ import (
 // Cropper crops picture according to configured reference ratio
 type Cropper int
 
-// Crop picture
-func (Cropper) Crop(im image.Image) image.Image {
-	switch option := viper.GetString("crop"); option {
+// Crop picture by crop and reference size in the setting, which are
+// usually merged by MergeRenderSetting
+func (Cropper) Crop(im image.Image, setting *viper.Viper) image.Image {
+	switch option := setting.GetString("crop"); option {
 	case "no":
 		return im
 	case "win-only":
@@ -31,7 +34,10 @@ func (Cropper) Crop(im image.Image) image.Image {
 	}
 
 	bounds := im.Bounds()
-	w, h := util.Viewpoint(float64(bounds.Dx()), float64(bounds.Dy()))
+	w, h := util.Viewpoint(
+		float64(bounds.Dx()), float64(bounds.Dy()),
+		setting.GetFloat64("reference-width"), setting.GetFloat64("reference-height"),
+	)
 	tCut := (float64(bounds.Dy()) - h) / 2
 	lCut := (float64(bounds.Dx()) - w) / 2
 	logrus.WithField(
@@ -72,5 +78,14 @@ func (Cropper) Crop(im image.Image) image.Image {
 	return newImg
 }
 
+// Process crops the picture as the crop actor
+func (c Cropper) Process(ctx context.Context, im image.Image, meta *channel.PictureMeta) (image.Image, error) {
+	return c.Crop(im, Setting(ctx)), nil
+}
+
 // DefaultCropper for convenience
 var DefaultCropper Cropper
+
+func init() {
+	Actors.Register("crop", DefaultCropper)
+}
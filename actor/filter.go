@@ -0,0 +1,151 @@
+package actor
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ErrRejected is returned when a picture doesn't pass the content filters or
+// has been banned
+var ErrRejected = errors.New("picture rejected")
+
+// sample at most filterSamples*filterSamples pixels to measure brightness
+// and contrast
+const filterSamples = 128
+
+// FilterSetting describes pictures acceptable as wallpaper. Zero values
+// disable the corresponding check
+type FilterSetting struct {
+	MinWidth  int `mapstructure:"min-width"`
+	MinHeight int `mapstructure:"min-height"`
+	// MinAspectRatio and MaxAspectRatio limit width/height of the picture
+	MinAspectRatio float64 `mapstructure:"min-aspect-ratio"`
+	MaxAspectRatio float64 `mapstructure:"max-aspect-ratio"`
+	// MinBrightness is the average luminance between 0 and 1
+	MinBrightness float64 `mapstructure:"min-brightness"`
+	// MinContrast is the standard deviation of luminance between 0 and 1
+	MinContrast float64 `mapstructure:"min-contrast"`
+	// IncludeKeywords requires the title or caption to contain one of the
+	// keywords, case-insensitive
+	IncludeKeywords []string `mapstructure:"include-keywords"`
+	// ExcludeKeywords rejects pictures whose title or caption contains any
+	// of the keywords, case-insensitive
+	ExcludeKeywords []string `mapstructure:"exclude-keywords"`
+}
+
+// LoadFilterSetting merges global filters with filters of the channel, the
+// latter wins on conflicts
+func LoadFilterSetting(setting *viper.Viper) (FilterSetting, error) {
+	filter := FilterSetting{}
+	merged := viper.New()
+	if err := merged.MergeConfigMap(util.Settings.GetStringMap("filters")); err != nil {
+		return filter, err
+	}
+	if setting != nil {
+		if err := merged.MergeConfigMap(setting.GetStringMap("filters")); err != nil {
+			return filter, err
+		}
+	}
+	err := merged.Unmarshal(&filter)
+	return filter, err
+}
+
+// Check returns an error wrapping ErrRejected with the reason if the picture
+// should not be used
+func (f FilterSetting) Check(im image.Image, meta *channel.PictureMeta) error {
+	if reason := f.checkMeta(meta); reason != "" {
+		return fmt.Errorf("%w: %s", ErrRejected, reason)
+	}
+	if im == nil {
+		return nil
+	}
+	if reason := f.checkImage(im); reason != "" {
+		return fmt.Errorf("%w: %s", ErrRejected, reason)
+	}
+	return nil
+}
+
+func (f FilterSetting) checkMeta(meta *channel.PictureMeta) string {
+	if meta == nil || (len(f.IncludeKeywords) == 0 && len(f.ExcludeKeywords) == 0) {
+		return ""
+	}
+	text := strings.ToLower(meta.Title + "\n" + meta.Caption)
+	for _, keyword := range f.ExcludeKeywords {
+		if k := strings.ToLower(strings.TrimSpace(keyword)); k != "" && strings.Contains(text, k) {
+			return fmt.Sprintf("title or caption contains excluded keyword %q", keyword)
+		}
+	}
+	if len(f.IncludeKeywords) == 0 {
+		return ""
+	}
+	for _, keyword := range f.IncludeKeywords {
+		if k := strings.ToLower(strings.TrimSpace(keyword)); k != "" && strings.Contains(text, k) {
+			return ""
+		}
+	}
+	return "title or caption contains none of the included keywords"
+}
+
+func (f FilterSetting) checkImage(im image.Image) string {
+	w, h := im.Bounds().Dx(), im.Bounds().Dy()
+	if w < f.MinWidth {
+		return fmt.Sprintf("width %d smaller than %d", w, f.MinWidth)
+	}
+	if h < f.MinHeight {
+		return fmt.Sprintf("height %d smaller than %d", h, f.MinHeight)
+	}
+	if h > 0 {
+		ratio := float64(w) / float64(h)
+		if f.MinAspectRatio > 0 && ratio < f.MinAspectRatio {
+			return fmt.Sprintf("aspect ratio %.3f lower than %.3f", ratio, f.MinAspectRatio)
+		}
+		if f.MaxAspectRatio > 0 && ratio > f.MaxAspectRatio {
+			return fmt.Sprintf("aspect ratio %.3f higher than %.3f", ratio, f.MaxAspectRatio)
+		}
+	}
+	if f.MinBrightness <= 0 && f.MinContrast <= 0 {
+		return ""
+	}
+	brightness, contrast := measureLuminance(im)
+	logrus.WithField("brightness", brightness).WithField("contrast", contrast).Debug("picture luminance measured")
+	if brightness < f.MinBrightness {
+		return fmt.Sprintf("brightness %.3f lower than %.3f", brightness, f.MinBrightness)
+	}
+	if contrast < f.MinContrast {
+		return fmt.Sprintf("contrast %.3f lower than %.3f", contrast, f.MinContrast)
+	}
+	return ""
+}
+
+// measureLuminance returns the mean and standard deviation of relative
+// luminance of sampled pixels, both between 0 and 1
+func measureLuminance(im image.Image) (mean, stddev float64) {
+	bounds := im.Bounds()
+	stepX := bounds.Dx()/filterSamples + 1
+	stepY := bounds.Dy()/filterSamples + 1
+
+	var sum, sumSq float64
+	var n int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, _ := im.At(x, y).RGBA()
+			lum := (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+			sum += lum
+			sumSq += lum * lum
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	mean = sum / float64(n)
+	return mean, math.Sqrt(math.Max(sumSq/float64(n)-mean*mean, 0))
+}
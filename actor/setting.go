@@ -0,0 +1,43 @@
+package actor
+
+import (
+	"fmt"
+
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ways to combine watermarks of a channel with the global ones
+const (
+	WatermarkReplace = "replace"
+	WatermarkAppend  = "append"
+)
+
+// RenderKeys are global settings of cropping and rendering, which a channel
+// can override in its definition
+var RenderKeys = []string{PipelineKey, "crop", "reference-width", "reference-height", "watermark"}
+
+// MergeRenderSetting fills settings of cropping and rendering not defined by
+// the channel with the global ones. Watermarks of the channel replace the
+// global ones, or are rendered after them if watermark-mode is append
+func MergeRenderSetting(setting *viper.Viper) error {
+	switch mode := setting.GetString("watermark-mode"); mode {
+	case "", WatermarkReplace:
+	case WatermarkAppend:
+		global, _ := util.Settings.Get("watermark").([]interface{})
+		own, _ := setting.Get("watermark").([]interface{})
+		merged := make([]interface{}, 0, len(global)+len(own))
+		setting.Set("watermark", append(append(merged, global...), own...))
+		logrus.WithField("global", len(global)).WithField("channel", len(own)).Debug("watermarks appended")
+	default:
+		return fmt.Errorf("unknown watermark-mode %s", mode)
+	}
+
+	for _, key := range RenderKeys {
+		if !setting.IsSet(key) {
+			setting.Set(key, util.Settings.Get(key))
+		}
+	}
+	return nil
+}
@@ -5,8 +5,8 @@ import (
 	"math"
 	"strings"
 
+	"github.com/genzj/goTApaper/util"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 
 	"github.com/fogleman/gg"
 )
@@ -39,12 +39,15 @@ const (
 type render struct {
 	ctx     *gg.Context
 	setting watermarkSetting
+	// refHeight scales font points unless absolute-point is set
+	refHeight float64
 }
 
-func newRender(im image.Image, setting watermarkSetting) render {
+func newRender(im image.Image, setting watermarkSetting, refHeight float64) render {
 	return render{
 		gg.NewContextForImage(im),
 		setting,
+		refHeight,
 	}
 }
 
@@ -63,7 +66,7 @@ func (r *render) loadTextColor() {
 func (r *render) normalizedPoint() float64 {
 	pixelDense := 1.0
 	if !r.setting.AbsolutePoint {
-		refHeight := viper.GetFloat64("reference-height")
+		refHeight := r.refHeight
 		if math.IsNaN(refHeight) || math.IsInf(refHeight, 0) || refHeight == 0 {
 			logrus.WithField("reference-height", refHeight).Warn("invalid reference height")
 		} else {
@@ -301,7 +304,7 @@ func (r *render) renderText(text string) error {
 	}
 
 	x, y, ax, ay, width := r.position(text)
-	if viper.GetBool("debug-rendering") {
+	if util.Settings.GetBool("debug-rendering") {
 		r.ctx.SetHexColor("ff00ff")
 		r.ctx.SetLineWidth(5)
 		r.ctx.DrawCircle(x, y, 10)
@@ -328,7 +331,7 @@ func (r *render) renderBackground(text string) {
 	x, y, ax, ay, width := r.position(text)
 	bx, by, bw, bh := r.boundOf(text, x, y, ax, ay, width)
 	r.ctx.DrawRectangle(bx, by, bw, bh)
-	if viper.GetBool("debug-rendering") {
+	if util.Settings.GetBool("debug-rendering") {
 		r.ctx.SetHexColor("ff00ff")
 		r.ctx.StrokePreserve()
 	}
@@ -0,0 +1,109 @@
+package watermark
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"text/template"
+
+	"github.com/genzj/goTApaper/util"
+)
+
+var hexColor = regexp.MustCompile(`^#?([0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+var validPositions = map[string]bool{
+	positionTopLeft: true, positionTopCenter: true, positionTopRight: true,
+	positionMiddleLeft: true, positionMiddleCenter: true, positionMiddleRight: true,
+	positionBottomLeft: true, positionBottomCenter: true, positionBottomRight: true,
+}
+
+var validAlignments = map[string]bool{
+	alignLeft: true, alignCenter: true, alignRight: true,
+}
+
+// Validate checks watermark settings in the same structure as the watermark
+// section of config file, and returns all problems found
+func Validate(watermarks interface{}) []error {
+	groups := watermarkGroups{}
+	if err := util.MapToStruct(map[string]interface{}{"watermark": watermarks}, &groups); err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for idx, setting := range groups.Watermark {
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("watermark[%d]: %s", idx, fmt.Sprintf(format, args...)))
+		}
+		if setting.Font == "" {
+			fail("font is required")
+		} else if _, err := findFont(setting.Font); err != nil {
+			fail("font %s not found", setting.Font)
+		}
+		if setting.Point <= 0 {
+			fail("point must be positive")
+		}
+		if setting.Color != "" && !hexColor.MatchString(setting.Color) {
+			fail("invalid color %s", setting.Color)
+		}
+		if setting.Background.Color != "" && !hexColor.MatchString(setting.Background.Color) {
+			fail("invalid background color %s", setting.Background.Color)
+		}
+		if setting.Position != "" && !validPositions[setting.Position] {
+			fail("invalid position %s", setting.Position)
+		}
+		if setting.Alignment != "" && !validAlignments[setting.Alignment] {
+			fail("invalid alignment %s", setting.Alignment)
+		}
+		if _, err := template.New("watermark").Parse(setting.Template); err != nil {
+			fail("invalid template: %s", err)
+		}
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Schema describes a watermark item of the config file in JSON Schema
+func Schema() util.Schema {
+	color := util.Schema{
+		"type":        []string{"string", "integer"},
+		"pattern":     "^$|" + hexColor.String(),
+		"description": "rrggbb or rrggbbaa in hexadecimal",
+	}
+	number := util.Schema{"type": "number"}
+	return util.Schema{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"font", "template"},
+		"properties": util.Schema{
+			"font":              util.Schema{"type": "string", "description": "TrueType font file name or path"},
+			"point":             util.Schema{"type": "number", "exclusiveMinimum": 0},
+			"absolute-point":    util.Schema{"type": "boolean"},
+			"absolute-position": util.Schema{"type": "boolean"},
+			"color":             color,
+			"position":          util.Schema{"type": "string", "enum": sortedKeys(validPositions)},
+			"alignment":         util.Schema{"type": "string", "enum": sortedKeys(validAlignments)},
+			"h-margin":          number,
+			"v-margin":          number,
+			"linespace":         number,
+			"template":          util.Schema{"type": "string", "description": "Go template of watermark text"},
+			"background": util.Schema{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": util.Schema{
+					"color":        color,
+					"h-throughout": util.Schema{"type": "boolean"},
+					"v-throughout": util.Schema{"type": "boolean"},
+					"paddings":     util.Schema{"type": "array", "items": number},
+				},
+			},
+		},
+	}
+}
@@ -1,6 +1,8 @@
 package watermark
 
 import (
+	"context"
+	"github.com/genzj/goTApaper/actor"
 	"github.com/genzj/goTApaper/config"
 	"github.com/genzj/goTApaper/util"
 	"image"
@@ -39,8 +41,31 @@ type watermarkGroups struct {
 	Watermark []watermarkSetting `mapstructure:"watermark"`
 }
 
-// Render watermark to the given image
-func Render(im image.Image, meta *channel.PictureMeta) (image.Image, error) {
+// Render watermarks and reference height in the setting to the given image.
+// The setting is usually merged by actor.MergeRenderSetting
+func Render(im image.Image, meta *channel.PictureMeta, setting *viper.Viper) (image.Image, error) {
+	return renderWatermarks(im, meta, setting.Get("watermark"), setting.GetFloat64("reference-height"))
+}
+
+// RenderWith renders watermarks in the given settings, which are in the same
+// structure as the watermark section of config file
+func RenderWith(im image.Image, meta *channel.PictureMeta, watermarks interface{}) (image.Image, error) {
+	return renderWatermarks(im, meta, watermarks, util.Settings.GetFloat64("reference-height"))
+}
+
+// Actor renders watermarks in the context setting as the watermark actor
+type Actor int
+
+// Process renders watermarks to the picture
+func (Actor) Process(ctx context.Context, im image.Image, meta *channel.PictureMeta) (image.Image, error) {
+	return Render(im, meta, actor.Setting(ctx))
+}
+
+func init() {
+	actor.Actors.Register("watermark", Actor(0))
+}
+
+func renderWatermarks(im image.Image, meta *channel.PictureMeta, watermarks interface{}, refHeight float64) (image.Image, error) {
 	type task struct {
 		text    string
 		setting watermarkSetting
@@ -48,7 +73,7 @@ func Render(im image.Image, meta *channel.PictureMeta) (image.Image, error) {
 	tasks := []task{}
 
 	groups := watermarkGroups{}
-	if err := viper.Unmarshal(&groups); err != nil {
+	if err := util.MapToStruct(map[string]interface{}{"watermark": watermarks}, &groups); err != nil {
 		logrus.WithError(err).Warn(
 			"cannot parse watermark settings, skip watermark rendering",
 		)
@@ -82,8 +107,8 @@ func Render(im image.Image, meta *channel.PictureMeta) (image.Image, error) {
 		tasks = append(tasks, task{text: text, setting: setting})
 	}
 
-	r := newRender(im, watermarkSetting{})
-	if viper.GetBool("debug-rendering") {
+	r := newRender(im, watermarkSetting{}, refHeight)
+	if util.Settings.GetBool("debug-rendering") {
 		minX, minY, maxX, maxY := r.limits()
 		w, h := r.size()
 		logrus.WithField(
@@ -135,7 +160,7 @@ func Render(im image.Image, meta *channel.PictureMeta) (image.Image, error) {
 	}
 
 	// layer-3 debug overlay
-	if viper.GetBool("debug-rendering") {
+	if util.Settings.GetBool("debug-rendering") {
 		logrus.Debugln("layer-3, debug overlay")
 		for _, task := range tasks {
 			r.updateSetting(task.setting)
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/data"
+	"github.com/genzj/goTApaper/history"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+)
+
+var errNoWallpaper = errors.New("no wallpaper applied yet")
+
+func (s *server) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+Prefix+"/status", s.status)
+	mux.HandleFunc("GET "+Prefix+"/current", s.current)
+	mux.HandleFunc("GET "+Prefix+"/current/image", s.currentImage)
+	mux.HandleFunc("GET "+Prefix+"/history", s.history)
+	mux.HandleFunc("GET "+Prefix+"/health", s.health)
+	mux.HandleFunc("GET "+Prefix+"/config", s.config)
+	mux.HandleFunc("POST "+Prefix+"/refresh", s.refresh)
+	mux.HandleFunc("POST "+Prefix+"/pause", s.pause)
+	mux.HandleFunc("POST "+Prefix+"/resume", s.resume)
+	mux.HandleFunc("POST "+Prefix+"/ban", s.ban)
+	mux.HandleFunc("POST "+Prefix+"/unban", s.unban)
+	mux.HandleFunc("POST "+Prefix+"/favourite", s.favourite)
+	mux.HandleFunc("GET "+Prefix+"/gallery", s.gallery)
+	mux.HandleFunc("GET "+Prefix+"/archive/{name}", serveDir(config.GetArchiveDir))
+	mux.HandleFunc("GET "+Prefix+"/favourites/{name}", serveDir(config.GetFavouritesDir))
+	mux.HandleFunc("GET "+Prefix+"/settings", s.settings)
+	mux.HandleFunc("PUT "+Prefix+"/settings", s.saveSettings)
+	mux.HandleFunc("POST "+Prefix+"/preview", s.preview)
+	mux.Handle("GET /ui/", http.StripPrefix("/ui", http.FileServer(data.WebUIAssets)))
+	mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.controller.Status())
+}
+
+func latest(w http.ResponseWriter) *history.Record {
+	record, err := history.JSONHistoryManagerSingleton.Latest()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil
+	} else if record == nil {
+		writeError(w, http.StatusNotFound, errNoWallpaper)
+		return nil
+	}
+	return record
+}
+
+func (s *server) current(w http.ResponseWriter, r *http.Request) {
+	if record := latest(w); record != nil {
+		writeJSON(w, http.StatusOK, record)
+	}
+}
+
+func (s *server) currentImage(w http.ResponseWriter, r *http.Request) {
+	if record := latest(w); record != nil {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFile(w, r, record.File)
+	}
+}
+
+func (s *server) history(w http.ResponseWriter, r *http.Request) {
+	records, err := history.JSONHistoryManagerSingleton.Records()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	all, err := history.JSONHealthManagerSingleton.LoadAll()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, all)
+}
+
+func (s *server) config(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, util.MaskSecrets(util.Settings.AllSettings()))
+}
+
+type refreshRequest struct {
+	Channels []string `json:"channels"`
+	Force    bool     `json:"force"`
+}
+
+func (s *server) refresh(w http.ResponseWriter, r *http.Request) {
+	req := refreshRequest{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.controller.Refresh(req.Channels, req.Force); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]bool{"ok": true})
+}
+
+func (s *server) pause(w http.ResponseWriter, r *http.Request) {
+	s.controller.SetPaused(true)
+	writeJSON(w, http.StatusOK, s.controller.Status())
+}
+
+func (s *server) resume(w http.ResponseWriter, r *http.Request) {
+	s.controller.SetPaused(false)
+	writeJSON(w, http.StatusOK, s.controller.Status())
+}
+
+type pictureRequest struct {
+	// URL of the picture, default to the current wallpaper
+	URL string `json:"url"`
+	// Favourite is false to unmark a favourite
+	Favourite *bool `json:"favourite"`
+}
+
+// findRecord fills in the URL of the current wallpaper if not specified,
+// and returns the latest record of the URL if any. current tells whether the
+// URL is of the current wallpaper
+func findRecord(req *pictureRequest) (record *history.Record, current bool, err error) {
+	records, err := history.JSONHistoryManagerSingleton.Records()
+	if err != nil {
+		return nil, false, err
+	}
+	if req.URL == "" {
+		if len(records) == 0 {
+			return nil, false, errNoWallpaper
+		}
+		req.URL = records[0].URL
+	}
+	for i := range records {
+		if records[i].URL == req.URL {
+			return &records[i], i == 0, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// ban the picture, and replace the wallpaper if it is the current one
+func (s *server) ban(w http.ResponseWriter, r *http.Request) {
+	req := pictureRequest{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	_, current, err := findRecord(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := history.JSONHistoryManagerSingleton.Ban(req.URL); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logrus.WithField("url", req.URL).Info("picture banned")
+	if current {
+		if err := s.controller.Refresh(nil, false); err != nil {
+			logrus.WithError(err).Warn("cannot replace banned wallpaper")
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"banned": req.URL})
+}
+
+func (s *server) unban(w http.ResponseWriter, r *http.Request) {
+	req := pictureRequest{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.URL == "" {
+		writeError(w, http.StatusBadRequest, errors.New("url is required"))
+		return
+	}
+	if err := history.JSONHistoryManagerSingleton.Unban(req.URL); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"unbanned": req.URL})
+}
+
+// favourite marks the picture, and keeps a copy of it if the current or
+// archived picture is available
+func (s *server) favourite(w http.ResponseWriter, r *http.Request) {
+	req := pictureRequest{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	record, current, err := findRecord(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	favourite := req.Favourite == nil || *req.Favourite
+
+	file := ""
+	if favourite && record != nil && record.FavouriteFile == "" {
+		if file, err = keepFavourite(record, current); err != nil {
+			logrus.WithError(err).Warn("cannot keep a copy of favourite picture")
+		}
+	}
+	if err := history.JSONHistoryManagerSingleton.SetFavourite(req.URL, favourite, file); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"url": req.URL, "favourite": favourite})
+}
+
+// keepFavourite copies the rendered picture into the favourites folder
+func keepFavourite(record *history.Record, current bool) (string, error) {
+	source := record.ArchiveFile
+	if current {
+		source = record.File
+	}
+	if source == "" {
+		return "", errors.New("picture is not archived")
+	}
+	dir := config.GetFavouritesDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%x%s", sha256.Sum256([]byte(record.URL)), filepath.Ext(source))
+	file := filepath.Join(dir, name)
+	if _, err := util.CopyFile(source, file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
@@ -0,0 +1,167 @@
+// Package api serves a REST API on a loopback address to inspect and
+// control the running daemon
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Controller is implemented by the daemon to be driven by the API
+type Controller interface {
+	// Refresh asks the daemon to refresh now, with all active channels if
+	// channels is empty
+	Refresh(channels []string, force bool) error
+	// SetPaused pauses or resumes scheduled refreshes
+	SetPaused(paused bool)
+	// Status describes the daemon, encoded into JSON as is
+	Status() interface{}
+}
+
+// Prefix of all API paths
+const Prefix = "/api/v1"
+
+type server struct {
+	controller Controller
+	token      string
+}
+
+// isLoopback tells whether the host is a loopback address or localhost
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// StartServer serves the API until ctx is done. The address must be a
+// loopback one, since the API can change the desktop and config. If token is
+// not empty, requests must carry it in the Authorization header as a bearer
+// token. Requests changing anything must be in JSON and, if sent by a
+// browser, from the API's own origin
+func StartServer(ctx context.Context, addr, token string, controller Controller) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if !isLoopback(host) {
+		return fmt.Errorf("API must listen on a loopback address, got %s", addr)
+	}
+
+	s := &server{controller: controller, token: token}
+	mux := http.NewServeMux()
+	s.register(mux)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{
+		Handler:           s.guard(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		logrus.WithField("addr", listener.Addr().String()).Info("API server started")
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).Error("API server stopped")
+		}
+	}()
+	return nil
+}
+
+// guard rejects requests with foreign Host header, which may come from DNS
+// rebinding attacks of web pages, cross-site requests changing anything, and
+// requests without the token
+func (s *server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if !isLoopback(host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %s not allowed", r.Host))
+			return
+		}
+		if err := checkSameSite(r); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		// static files of web UI are public, the UI asks for the token
+		if s.token != "" && strings.HasPrefix(r.URL.Path, Prefix+"/") && !s.authorized(r) {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkSameSite rejects requests changing anything which a web page of
+// another site can send without preflight, i.e. those not in JSON or with a
+// foreign Origin header
+func checkSameSite(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return errors.New("content type must be application/json")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme != "http" || u.Host != r.Host {
+			return fmt.Errorf("origin %s not allowed", origin)
+		}
+	}
+	return nil
+}
+
+func (s *server) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		logrus.WithError(err).Warn("cannot write API response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// readJSON decodes the request body into v, an empty body is allowed
+func readJSON(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20)).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/genzj/goTApaper/actor/watermark"
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/history"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// maskedValue replaces secrets in responses, and means "unchanged" in
+// settings sent back by the web UI
+const maskedValue = util.MaskedValue
+
+// editableKeys are config keys the web UI can change
+var editableKeys = []string{"active-channels", "channels", "watermark"}
+
+// galleryItem is a history record with links to its pictures
+type galleryItem struct {
+	history.Record
+	Banned bool
+	// Image links to the archived or favourite copy, empty if none is kept
+	Image string `json:",omitempty"`
+}
+
+// imageLink returns the API path serving the file in the folder, or empty if
+// the file is gone
+func imageLink(file, dir, path string) string {
+	if file == "" || filepath.Dir(file) != filepath.Clean(dir) {
+		return ""
+	}
+	if _, err := os.Stat(file); err != nil {
+		return ""
+	}
+	return Prefix + path + filepath.Base(file)
+}
+
+func (s *server) gallery(w http.ResponseWriter, r *http.Request) {
+	records, err := history.JSONHistoryManagerSingleton.Records()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	items := make([]galleryItem, 0, len(records))
+	for idx, record := range records {
+		item := galleryItem{
+			Record: record,
+			Banned: history.JSONHistoryManagerSingleton.IsBanned(record.URL),
+			Image:  imageLink(record.ArchiveFile, config.GetArchiveDir(), "/archive/"),
+		}
+		if item.Image == "" {
+			item.Image = imageLink(record.FavouriteFile, config.GetFavouritesDir(), "/favourites/")
+		}
+		if item.Image == "" && idx == 0 {
+			item.Image = Prefix + "/current/image"
+		}
+		items = append(items, item)
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// serveDir serves files directly inside the folder returned by dir
+func serveDir(dir func() string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Base(r.PathValue("name"))
+		if name == "." || name == string(filepath.Separator) {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(dir(), name))
+	}
+}
+
+// settingsResponse carries editable settings, with secrets masked, and
+// choices available to them
+type settingsResponse struct {
+	Settings     map[string]interface{} `json:"settings"`
+	ChannelTypes []string               `json:"channelTypes"`
+}
+
+func (s *server) settings(w http.ResponseWriter, r *http.Request) {
+	current := make(map[string]interface{}, len(editableKeys))
+	for _, key := range editableKeys {
+		current[key] = util.Settings.Get(key)
+	}
+	types := make([]string, 0, len(channel.Channels.RegistryMap))
+	for name := range channel.Channels.RegistryMap {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	writeJSON(w, http.StatusOK, settingsResponse{
+		Settings:     util.MaskSecrets(current),
+		ChannelTypes: types,
+	})
+}
+
+// unmaskSecrets puts back values of the old settings where the web UI sends
+// the masked placeholder, or the URL with its password masked
+func unmaskSecrets(value, old interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v == maskedValue {
+			return old
+		}
+		if oldString, ok := old.(string); ok && oldString != v && util.MaskURLString(oldString) == v {
+			return old
+		}
+	case map[string]interface{}:
+		oldMap, _ := old.(map[string]interface{})
+		for key, item := range v {
+			v[key] = unmaskSecrets(item, oldMap[key])
+		}
+	}
+	return value
+}
+
+// checkSecretRefs rejects file and command secret references not already in
+// the config, since they would let any client of the API read files or run
+// commands as the daemon user. Literal values and env references are fine
+func checkSecretRefs(value, old interface{}, path string) []error {
+	if kind, _, ok := util.SecretRef(value); ok {
+		if kind != util.SecretEnv && !reflect.DeepEqual(value, old) {
+			return []error{fmt.Errorf("%s: %s secrets cannot be set from the web UI", path, kind)}
+		}
+		return nil
+	}
+	var errs []error
+	switch v := value.(type) {
+	case map[string]interface{}:
+		oldMap, _ := old.(map[string]interface{})
+		for key, item := range v {
+			errs = append(errs, checkSecretRefs(item, oldMap[key], path+"."+key)...)
+		}
+	case []interface{}:
+		oldList, _ := old.([]interface{})
+		for idx, item := range v {
+			var oldItem interface{}
+			if idx < len(oldList) {
+				oldItem = oldList[idx]
+			}
+			errs = append(errs, checkSecretRefs(item, oldItem, fmt.Sprintf("%s[%d]", path, idx))...)
+		}
+	}
+	return errs
+}
+
+// validateSettings checks the settings as a whole, returning all problems
+func validateSettings(settings map[string]interface{}) []error {
+	var errs []error
+	channels, ok := settings["channels"].(map[string]interface{})
+	if !ok {
+		return []error{errors.New("channels must be a mapping")}
+	}
+	for name, definition := range channels {
+		ch, ok := definition.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("channels.%s must be a mapping", name))
+			continue
+		}
+		typ, _ := ch["type"].(string)
+		if _, ok := channel.Channels.Get(typ); !ok {
+			errs = append(errs, fmt.Errorf("channels.%s: unknown type %q", name, typ))
+		}
+		for _, err := range watermark.Validate(ch["watermark"]) {
+			errs = append(errs, fmt.Errorf("channels.%s.%w", name, err))
+		}
+	}
+
+	active, ok := settings["active-channels"].([]interface{})
+	if !ok {
+		errs = append(errs, errors.New("active-channels must be a list"))
+	}
+	for idx, item := range active {
+		name, p := "", 1.0
+		switch v := item.(type) {
+		case string:
+			name = v
+		case map[string]interface{}:
+			if len(v) != 1 {
+				errs = append(errs, fmt.Errorf("active-channels[%d]: multiple channels in one item", idx))
+				continue
+			}
+			for k, prob := range v {
+				name = k
+				if p, ok = prob.(float64); !ok {
+					errs = append(errs, fmt.Errorf("active-channels[%d]: probability must be a number", idx))
+				}
+			}
+		default:
+			errs = append(errs, fmt.Errorf("active-channels[%d]: invalid item", idx))
+			continue
+		}
+		if _, ok := channels[name]; !ok {
+			errs = append(errs, fmt.Errorf("active-channels[%d]: channel %s not defined", idx, name))
+		}
+		if p < 0 || p > 1 {
+			errs = append(errs, fmt.Errorf("active-channels[%d]: probability must be within [0, 1]", idx))
+		}
+	}
+
+	return append(errs, watermark.Validate(settings["watermark"])...)
+}
+
+type validationErrors struct {
+	Error  string   `json:"error"`
+	Errors []string `json:"errors"`
+}
+
+// saveSettings validates the settings sent by the web UI, then writes them to
+// the config file and applies them. It is refused without a token, as the
+// config file decides what the daemon runs
+func (s *server) saveSettings(w http.ResponseWriter, r *http.Request) {
+	if s.token == "" {
+		writeError(w, http.StatusForbidden, errors.New("set api.token to change settings from the web UI"))
+		return
+	}
+	settings := map[string]interface{}{}
+	if err := readJSON(r, &settings); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var errs []error
+	for _, key := range editableKeys {
+		if _, ok := settings[key]; !ok {
+			settings[key] = util.Settings.Get(key)
+		}
+		settings[key] = unmaskSecrets(settings[key], util.Settings.Get(key))
+		errs = append(errs, checkSecretRefs(settings[key], util.Settings.Get(key), key)...)
+	}
+	if errs = append(errs, validateSettings(settings)...); len(errs) > 0 {
+		resp := validationErrors{Error: "invalid settings"}
+		for _, err := range errs {
+			resp.Errors = append(resp.Errors, err.Error())
+		}
+		sort.Strings(resp.Errors)
+		writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	if err := config.UpdateConfig(settings); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logrus.WithField("file", viper.ConfigFileUsed()).Info("settings saved from web UI")
+	s.settings(w, r)
+}
+
+// previewRequest carries the watermark settings to preview
+type previewRequest struct {
+	Watermark interface{} `json:"watermark"`
+}
+
+// preview renders the watermarks on a blank picture of the reference size,
+// with texts of the current wallpaper
+func (s *server) preview(w http.ResponseWriter, r *http.Request) {
+	req := previewRequest{}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errs := watermark.Validate(req.Watermark); len(errs) > 0 {
+		writeError(w, http.StatusBadRequest, errors.Join(errs...))
+		return
+	}
+
+	width, height := util.Settings.GetInt("reference-width"), util.Settings.GetInt("reference-height")
+	if width <= 0 || height <= 0 {
+		width, height = 1920, 1080
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{C: color.Gray{Y: 0x60}}, image.Point{}, draw.Src)
+
+	meta := &channel.PictureMeta{Title: "Title", Caption: "Caption", Credit: "Credit", Channel: "channel"}
+	if record, err := history.JSONHistoryManagerSingleton.Latest(); err == nil && record != nil {
+		meta = &channel.PictureMeta{
+			Title:      record.Title,
+			Caption:    record.Caption,
+			Credit:     record.Credit,
+			Format:     record.Format,
+			Channel:    record.Channel,
+			ChannelKey: record.ChannelKey,
+			URL:        record.URL,
+		}
+	}
+
+	im, err := watermark.RenderWith(canvas, meta, req.Watermark)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, im); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -2,6 +2,7 @@ package channel
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"image"
 	"strings"
@@ -69,7 +70,7 @@ func splitBingCopyright(copyright string, meta *PictureMeta) {
 	meta.Credit = copyright[offset+1 : ending]
 }
 
-func bingFindFirstFit(setting *viper.Viper, urlBase string) string {
+func bingFindFirstFit(ctx context.Context, setting *viper.Viper, urlBase string) string {
 	var finalURL string
 	var array sizeArray
 	ret := ""
@@ -90,7 +91,7 @@ func bingFindFirstFit(setting *viper.Viper, urlBase string) string {
 
 	for _, size := range array {
 		finalURL = bingBaseURL + urlBase + "_" + size.size + ".jpg"
-		if util.IsReachableLink(finalURL) {
+		if util.IsReachableLink(ctx, finalURL) {
 			return finalURL
 		}
 	}
@@ -99,7 +100,7 @@ func bingFindFirstFit(setting *viper.Viper, urlBase string) string {
 
 type bingWallpaperChannelProvider int
 
-func (bingWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
+func (bingWallpaperChannelProvider) Download(ctx context.Context, setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
 	var response bingResponse
 
 	historyManager := history.JSONHistoryManagerSingleton
@@ -110,14 +111,14 @@ func (bingWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.Reade
 	logrus.Debugf("history of %s channel: %+v", bingChannelName, h)
 
 	// TODO add market as parameter
-	if err := util.ReadJSON(bingGalleryURL, &response); err != nil {
+	if err := util.ReadJSON(ctx, bingGalleryURL, &response); err != nil {
 		return nil, nil, nil, err
 	}
 
 	logrus.Debugf("JSON loaded %+v", response)
 
 	item := response.Images[0]
-	finalURL := bingFindFirstFit(setting, item.URLBase)
+	finalURL := bingFindFirstFit(ctx, setting, item.URLBase)
 
 	logrus.WithField(
 		"finalUrl", finalURL,
@@ -142,6 +143,7 @@ func (bingWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.Reade
 		meta.UploadTime = meta.UploadTime.Local()
 	}
 	meta.DownloadTime = time.Now()
+	meta.URL = finalURL
 
 	// TODO extract following part as util function
 	if !setting.GetBool("force") && h.Has(finalURL) {
@@ -149,7 +151,7 @@ func (bingWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.Reade
 		return nil, nil, meta, nil
 	}
 
-	resp, err := util.GetInType(finalURL, "image/jpeg")
+	resp, err := util.GetInType(ctx, finalURL, "image/jpeg")
 	if err != nil {
 		return nil, nil, meta, err
 	}
@@ -165,6 +167,12 @@ func (bingWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.Reade
 	return raw, img, meta, nil
 }
 
+func (bingWallpaperChannelProvider) Options() util.Schema {
+	return util.Schema{
+		"strategy": util.Schema{"type": "string", "enum": []string{config.LargestNoLogo, config.Largest}},
+	}
+}
+
 func init() {
 	var me bingWallpaperChannelProvider
 	Channels.Register(bingChannelName, me)
@@ -0,0 +1,17 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBingReplay(t *testing.T) {
+	meta := runReplay(t, bingChannelName, viper.New())
+	checkMeta(t, "title", meta.Title, "A quiet lake at dawn")
+	checkMeta(t, "credit", meta.Credit, "© Test Photographer/Getty Images")
+	checkMeta(t, "url", meta.URL, "https://www.bing.com/th?id=OHR.TestLake_EN-US0000000000_UHD.jpg")
+	if meta.UploadTime.UTC().Format("200601021504") != "202610180700" {
+		t.Errorf("upload time = %s", meta.UploadTime)
+	}
+}
@@ -2,6 +2,7 @@ package channel
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"time"
@@ -18,13 +19,23 @@ type PictureMeta struct {
 	Format       string
 	Channel      string
 	ChannelKey   string
+	URL          string
 	UploadTime   time.Time
 	DownloadTime time.Time
 }
 
-// Channel defines a wallpaper downloader
+// Channel defines a wallpaper downloader. Implementations should pass the
+// context to all network operations so that a download can be cancelled or
+// timed out
 type Channel interface {
-	Download(*viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error)
+	Download(context.Context, *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error)
+}
+
+// OptionsDescriber is optionally implemented by channels to describe their
+// own options in JSON Schema properties, so that the config file can be
+// validated
+type OptionsDescriber interface {
+	Options() util.Schema
 }
 
 type channelMap struct {
@@ -35,10 +46,10 @@ func (m *channelMap) Register(name string, ch Channel) {
 	m.RegistryMap.Register(name, ch)
 }
 
-func (m channelMap) Run(name string, setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
+func (m channelMap) Run(ctx context.Context, name string, setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
 	if v, ok := m.Get(name); ok {
 		ch := v.(Channel)
-		return ch.Download(setting)
+		return ch.Download(ctx, setting)
 	}
 	return nil, nil, nil, fmt.Errorf("channel %s not registered", name)
 }
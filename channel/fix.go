@@ -2,6 +2,7 @@ package channel
 
 import (
 	"bytes"
+	"context"
 	"image"
 	"net/url"
 	"time"
@@ -17,7 +18,7 @@ const (
 
 type fixedPictureProvider int
 
-func (fixedPictureProvider) Download(setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
+func (fixedPictureProvider) Download(ctx context.Context, setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
 	// fill metadata
 	meta := &PictureMeta{}
 	meta.DownloadTime = time.Now()
@@ -33,6 +34,7 @@ func (fixedPictureProvider) Download(setting *viper.Viper) (*bytes.Reader, image
 	}
 
 	finalURL := setting.GetString("url")
+	meta.URL = finalURL
 	if finalURL == "" {
 		logrus.Infoln("blank url, ignore")
 		return nil, nil, meta, nil
@@ -49,7 +51,7 @@ func (fixedPictureProvider) Download(setting *viper.Viper) (*bytes.Reader, image
 		meta.Format = format
 		return raw, img, meta, err
 	} else {
-		resp, err := util.GetInType(finalURL, "image/")
+		resp, err := util.GetInType(ctx, finalURL, "image/")
 		if err != nil {
 			return nil, nil, meta, err
 		}
@@ -60,6 +62,22 @@ func (fixedPictureProvider) Download(setting *viper.Viper) (*bytes.Reader, image
 	}
 }
 
+func (fixedPictureProvider) Options() util.Schema {
+	return util.Schema{
+		"url": util.Schema{"type": "string", "description": "http(s) or file URL of the picture"},
+		"meta": util.Schema{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": util.Schema{
+				"title":       util.Schema{"type": "string"},
+				"caption":     util.Schema{"type": "string"},
+				"credit":      util.Schema{"type": "string"},
+				"upload-time": util.Schema{"type": []string{"string", "integer"}, "pattern": `^\d{12}$`, "description": "in format yyyyMMddhhmm"},
+			},
+		},
+	}
+}
+
 func init() {
 	var me fixedPictureProvider
 	Channels.Register(fixChannelName, me)
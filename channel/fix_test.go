@@ -0,0 +1,17 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestFixedReplay(t *testing.T) {
+	setting := viper.New()
+	setting.Set("url", "https://example.com/wallpaper.jpg")
+	setting.Set("meta.title", "Wallpaper")
+	setting.Set("meta.upload-time", "202610180700")
+	meta := runReplay(t, fixChannelName, setting)
+	checkMeta(t, "title", meta.Title, "Wallpaper")
+	checkMeta(t, "url", meta.URL, "https://example.com/wallpaper.jpg")
+}
@@ -2,6 +2,7 @@ package channel
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/PaesslerAG/jsonpath"
@@ -82,7 +83,7 @@ func extractConfigJSON(r io.Reader) ([]byte, error) {
 
 type ngPoTChannelProvider int
 
-func (ngPoTChannelProvider) Download(setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
+func (ngPoTChannelProvider) Download(ctx context.Context, setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
 	var page map[string]interface{}
 	historyManager := history.JSONHistoryManagerSingleton
 	h, err := historyManager.Load(ngChannelName)
@@ -92,7 +93,7 @@ func (ngPoTChannelProvider) Download(setting *viper.Viper) (*bytes.Reader, image
 
 	logrus.Debugf("history of %s channel: %+v", ngChannelName, h)
 
-	if err := util.ExtractJSON(ngBaseURL, &page, extractConfigJSON); err != nil {
+	if err := util.ExtractJSON(ctx, ngBaseURL, &page, extractConfigJSON); err != nil {
 		return nil, nil, nil, err
 	}
 
@@ -161,6 +162,7 @@ func (ngPoTChannelProvider) Download(setting *viper.Viper) (*bytes.Reader, image
 	}
 
 	finalURL := base.ResolveReference(downloadURL).String()
+	meta.URL = finalURL
 
 	logrus.WithField(
 		"picURL", picURL,
@@ -179,7 +181,7 @@ func (ngPoTChannelProvider) Download(setting *viper.Viper) (*bytes.Reader, image
 		return nil, nil, meta, nil
 	}
 
-	resp, err := util.GetInType(finalURL, "image/jpeg")
+	resp, err := util.GetInType(ctx, finalURL, "image/jpeg")
 	if err != nil {
 		return nil, nil, meta, err
 	}
@@ -197,6 +199,10 @@ func (ngPoTChannelProvider) Download(setting *viper.Viper) (*bytes.Reader, image
 	return raw, img, meta, nil
 }
 
+func (ngPoTChannelProvider) Options() util.Schema {
+	return util.Schema{}
+}
+
 func init() {
 	var me ngPoTChannelProvider
 	Channels.Register(ngChannelName, me)
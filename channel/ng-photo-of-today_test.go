@@ -0,0 +1,15 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestNGPhotoOfTodayReplay(t *testing.T) {
+	meta := runReplay(t, ngChannelName, viper.New())
+	checkMeta(t, "title", meta.Title, "A Quiet Lake")
+	checkMeta(t, "caption", meta.Caption, "Mist rises over a quiet lake at dawn.")
+	checkMeta(t, "credit", meta.Credit, "Photograph by Test Photographer")
+	checkMeta(t, "url", meta.URL, "https://i.natgeofe.com/n/00000000-test/test-lake.jpg")
+}
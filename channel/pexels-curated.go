@@ -2,6 +2,7 @@ package channel
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"image"
 	"net/http"
@@ -63,7 +64,7 @@ func extractTitleFromURL(pageURL string) string {
 
 type pexelsCuratedChannelProvider int
 
-func (pexelsCuratedChannelProvider) Download(setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
+func (pexelsCuratedChannelProvider) Download(ctx context.Context, setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
 	historyManager := history.JSONHistoryManagerSingleton
 	h, err := historyManager.Load(pexelsCuratedChannelName)
 	if err != nil {
@@ -75,7 +76,7 @@ func (pexelsCuratedChannelProvider) Download(setting *viper.Viper) (*bytes.Reade
 	meta.Channel = pexelsCuratedChannelName
 	response := pexelsCuratedResponse{}
 
-	req, err := http.NewRequest("GET", pexelsCuratedURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", pexelsCuratedURL, nil)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -115,6 +116,7 @@ func (pexelsCuratedChannelProvider) Download(setting *viper.Viper) (*bytes.Reade
 		params.Add("dpr", setting.GetString("dpr"))
 	}
 	finalURL := photoURL + "?" + params.Encode()
+	meta.URL = finalURL
 	logrus.WithField("photo-URL", finalURL).Debug("downloading photo")
 
 	if !setting.GetBool("force") && h.Has(finalURL) {
@@ -122,7 +124,7 @@ func (pexelsCuratedChannelProvider) Download(setting *viper.Viper) (*bytes.Reade
 		return nil, nil, meta, nil
 	}
 
-	resp, err := util.GetInType(finalURL, "image/")
+	resp, err := util.GetInType(ctx, finalURL, "image/")
 	if err != nil {
 		return nil, nil, meta, err
 	}
@@ -138,6 +140,17 @@ func (pexelsCuratedChannelProvider) Download(setting *viper.Viper) (*bytes.Reade
 	return raw, img, meta, err
 }
 
+func (pexelsCuratedChannelProvider) Options() util.Schema {
+	size := util.Schema{"type": []string{"integer", "string"}}
+	return util.Schema{
+		"key":      util.Schema{"type": "string", "description": "Pexels API key"},
+		"strategy": util.Schema{"type": "string", "enum": []string{config.BySize, config.Largest}},
+		"width":    size,
+		"height":   size,
+		"dpr":      util.Schema{"type": []string{"number", "string"}},
+	}
+}
+
 func init() {
 	var me pexelsCuratedChannelProvider
 	Channels.Register(pexelsCuratedChannelName, me)
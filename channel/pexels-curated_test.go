@@ -0,0 +1,16 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestPexelsCuratedReplay(t *testing.T) {
+	setting := viper.New()
+	setting.Set("key", "TESTKEY")
+	meta := runReplay(t, pexelsCuratedChannelName, setting)
+	checkMeta(t, "title", meta.Title, "A Quiet Lake At Dawn")
+	checkMeta(t, "credit", meta.Credit, "Test Photographer")
+	checkMeta(t, "url", meta.URL, "https://images.pexels.com/photos/1000000/pexels-photo-1000000.jpeg?auto=compress&cs=tinysrgb&fit=crop")
+}
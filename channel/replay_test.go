@@ -0,0 +1,57 @@
+package channel
+
+import (
+	"context"
+	"image"
+	"path/filepath"
+	"testing"
+
+	"github.com/genzj/goTApaper/util"
+	"github.com/spf13/viper"
+)
+
+// replayContext serves http requests of the test from fixtures recorded in
+// testdata/<name>, with history kept in a temporary file
+func replayContext(t *testing.T, name string) context.Context {
+	t.Helper()
+	viper.Set("history-file", filepath.Join(t.TempDir(), "history.json"))
+	util.SetReplayMode(util.ReplayModeReplay, filepath.Join("testdata", name))
+	t.Cleanup(func() {
+		util.SetReplayMode(util.ReplayModeOff, "")
+		viper.Reset()
+	})
+
+	client, err := util.NewClient(util.LoadHTTPSettings(nil))
+	if err != nil {
+		t.Fatalf("cannot create http client: %s", err)
+	}
+	return util.WithClient(context.Background(), client)
+}
+
+// runReplay downloads from the channel against its fixtures and checks the
+// picture is decoded
+func runReplay(t *testing.T, name string, setting *viper.Viper) *PictureMeta {
+	t.Helper()
+	ctx := replayContext(t, name)
+	raw, img, meta, err := Channels.Run(ctx, name, setting)
+	if err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+	if raw == nil || img == nil || meta == nil {
+		t.Fatalf("nothing downloaded: raw %v, image %v, meta %v", raw, img, meta)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 32, 18); got != want {
+		t.Errorf("picture bounds = %v, want %v", got, want)
+	}
+	if meta.Format != "jpeg" {
+		t.Errorf("format = %q, want jpeg", meta.Format)
+	}
+	return meta
+}
+
+func checkMeta(t *testing.T, field, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s = %q, want %q", field, got, want)
+	}
+}
@@ -2,13 +2,16 @@ package channel
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 	"image"
 	"net/url"
+	"strings"
 	"time"
 
+	"github.com/genzj/goTApaper/config"
 	"github.com/genzj/goTApaper/util"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -18,6 +21,8 @@ const (
 	unsplashChannelName = "unsplash"
 	unsplashBaseURL     = "https://api.unsplash.com"
 	unsplashGalleryURL  = unsplashBaseURL + "/photos/random"
+
+	unsplashReportTimeout = 30 * time.Second
 )
 
 type photoItem struct {
@@ -64,7 +69,7 @@ func getListQuery(setting *viper.Viper) string {
 		v.Set("featured", "")
 	}
 
-	logrus.Debugf("query for photo list: %s", v.Encode())
+	logrus.Debugf("query for photo list: %s", util.MaskQuery(v))
 	return v.Encode()
 }
 
@@ -88,19 +93,19 @@ func getPhotoQuery(setting *viper.Viper) string {
 		v.Set(key, val)
 	}
 
-	logrus.Debugf("query for photo download: %s", v.Encode())
+	logrus.Debugf("query for photo download: %s", util.MaskQuery(v))
 	return v.Encode()
 }
 
 type unsplashWallpaperChannelProvider int
 
-func (unsplashWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
+func (unsplashWallpaperChannelProvider) Download(ctx context.Context, setting *viper.Viper) (*bytes.Reader, image.Image, *PictureMeta, error) {
 	if getClientID(setting) == "" {
 		return nil, nil, nil, fmt.Errorf("unsplash API access key not set")
 	}
 	query := getListQuery(setting)
 	response := photoItem{}
-	if err := util.ReadJSON(unsplashGalleryURL+"?"+query, &response); err != nil {
+	if err := util.ReadJSON(ctx, unsplashGalleryURL+"?"+query, &response); err != nil {
 		return nil, nil, nil, err
 	}
 	logrus.Debugf("JSON loaded %+v", response)
@@ -130,8 +135,14 @@ func (unsplashWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.R
 
 	// do my best to obey Unsplash API guidelines:
 	// https://help.unsplash.com/api-guidelines/more-on-each-guideline/guideline-triggering-a-download
+	// the report outlives this download, so detach it from the channel
+	// timeout but still give it a deadline of its own
 	go func() {
-		resp, err := util.Get(response.Links.Download)
+		reportCtx, cancel := context.WithTimeout(
+			context.WithoutCancel(ctx), unsplashReportTimeout,
+		)
+		defer cancel()
+		resp, err := util.Get(reportCtx, response.Links.Download)
 		if err != nil {
 			logrus.Warnf("report download failed: %s", err)
 		}
@@ -147,8 +158,15 @@ func (unsplashWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.R
 		return nil, nil, meta, fmt.Errorf("cannot get photo from unsplash API")
 	}
 
-	finalURL := response.URLs.Raw + getPhotoQuery(setting)
-	resp, err := util.GetInType(finalURL, "image/jpeg")
+	// raw URLs come with query parameters like ixid already
+	separator := "?"
+	if strings.Contains(response.URLs.Raw, "?") {
+		separator = "&"
+	}
+	finalURL := response.URLs.Raw + separator + getPhotoQuery(setting)
+	// the final URL carries the access key, so only the raw one is kept
+	meta.URL = response.URLs.Raw
+	resp, err := util.GetInType(ctx, finalURL, "image/jpeg")
 	if err != nil {
 		return nil, nil, meta, err
 	}
@@ -157,6 +175,21 @@ func (unsplashWallpaperChannelProvider) Download(setting *viper.Viper) (*bytes.R
 	return raw, img, meta, err
 }
 
+func (unsplashWallpaperChannelProvider) Options() util.Schema {
+	return util.Schema{
+		"key":         util.Schema{"type": "string", "description": "Unsplash access key"},
+		"query":       util.Schema{"type": "string"},
+		"orientation": util.Schema{"type": "string", "enum": []string{"landscape", "portrait", "squarish"}},
+		"featured":    util.Schema{"type": "boolean"},
+		"strategy":    util.Schema{"type": "string", "enum": []string{config.ByWidth, config.Largest}},
+		"width":       util.Schema{"type": []string{"integer", "string"}},
+		"image_parameters": util.Schema{
+			"type":                 "object",
+			"additionalProperties": util.Schema{"type": []string{"string", "number", "boolean"}},
+		},
+	}
+}
+
 func init() {
 	var me unsplashWallpaperChannelProvider
 	Channels.Register(unsplashChannelName, me)
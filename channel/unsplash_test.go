@@ -0,0 +1,16 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestUnsplashReplay(t *testing.T) {
+	setting := viper.New()
+	setting.Set("key", "TESTKEY")
+	meta := runReplay(t, unsplashChannelName, setting)
+	checkMeta(t, "title", meta.Title, "A Quiet Lake At Dawn")
+	checkMeta(t, "credit", meta.Credit, "Test Photographer")
+	checkMeta(t, "url", meta.URL, "https://images.unsplash.com/photo-1000000000000-test?ixid=test")
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/genzj/goTApaper/api"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+)
+
+// apiController lets the REST API drive the daemon loop
+type apiController struct {
+	ctx         context.Context
+	nextCycleCh nextCycleChannel
+}
+
+func (c apiController) Refresh(channels []string, force bool) error {
+	return requestCycle(c.ctx, c.nextCycleCh, cycleRequest{channels: channels, force: force})
+}
+
+func (c apiController) SetPaused(paused bool) {
+	currentDaemon.setPaused(paused)
+	logrus.WithField("paused", paused).Info("daemon pause state changed by API")
+}
+
+func (c apiController) Status() interface{} {
+	return currentDaemon.snapshot()
+}
+
+// startAPI serves the REST API if enabled in config
+func startAPI(ctx context.Context, nextCycleCh nextCycleChannel) {
+	if !util.Settings.GetBool("api.enabled") {
+		return
+	}
+	addr := util.Settings.GetString("api.listen")
+	token := util.Settings.GetString("api.token")
+	if kind, ref, ok := util.SecretRef(util.Settings.Get("api.token")); ok {
+		var err error
+		if token, err = util.ResolveSecret(kind, ref); err != nil {
+			logrus.WithError(err).Error("cannot resolve API token, API server not started")
+			return
+		}
+	}
+	if token == "" {
+		logrus.Warn("API token not set, any local user can control the daemon")
+	}
+	if err := api.StartServer(ctx, addr, token, apiController{ctx: ctx, nextCycleCh: nextCycleCh}); err != nil {
+		logrus.WithError(err).WithField("addr", addr).Error("cannot start API server")
+	}
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/history"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+)
+
+// recordWallpaper keeps the wallpaper applied in history, with a copy in the
+// archive folder if archiving is enabled
+func recordWallpaper(meta *channel.PictureMeta) {
+	record := history.Record{
+		URL:        meta.URL,
+		Channel:    meta.Channel,
+		ChannelKey: meta.ChannelKey,
+		Title:      meta.Title,
+		Caption:    meta.Caption,
+		Credit:     meta.Credit,
+		Format:     meta.Format,
+		File:       config.GetWallpaperFileName() + "." + meta.Format,
+		AppliedAt:  time.Now(),
+	}
+	if util.Settings.GetInt("archive.size") > 0 {
+		record.ArchiveFile = archiveWallpaper(record)
+	}
+	if err := history.JSONHistoryManagerSingleton.AddRecord(record); err != nil {
+		logrus.WithError(err).Warn("cannot record wallpaper in history")
+	}
+	pruneArchive()
+}
+
+// archiveNamePattern matches names given by archiveWallpaper, so that pruning
+// leaves other files alone if archive.dir is shared with them
+var archiveNamePattern = regexp.MustCompile(`^[0-9a-f]{64}-[0-9]+(\.[0-9A-Za-z]+)?$`)
+
+func archiveWallpaper(record history.Record) string {
+	dir := config.GetArchiveDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.WithError(err).Warn("cannot create archive dir")
+		return ""
+	}
+	name := fmt.Sprintf("%x-%d%s", sha256.Sum256([]byte(record.URL)), record.AppliedAt.Unix(), filepath.Ext(record.File))
+	file := filepath.Join(dir, name)
+	if _, err := util.CopyFile(record.File, file); err != nil {
+		logrus.WithError(err).Warn("cannot archive wallpaper")
+		return ""
+	}
+	return file
+}
+
+// pruneArchive removes archived pictures not belonging to the latest
+// archive.size records. Files not named by archiveWallpaper are kept
+func pruneArchive() {
+	records, err := history.JSONHistoryManagerSingleton.Records()
+	if err != nil {
+		return
+	}
+	keep := make(map[string]bool)
+	for i, r := range records {
+		if i < util.Settings.GetInt("archive.size") && r.ArchiveFile != "" {
+			keep[filepath.Base(r.ArchiveFile)] = true
+		}
+	}
+	entries, err := os.ReadDir(config.GetArchiveDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || keep[entry.Name()] || !archiveNamePattern.MatchString(entry.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(config.GetArchiveDir(), entry.Name())); err != nil {
+			logrus.WithError(err).Warn("cannot prune archived wallpaper")
+		}
+	}
+}
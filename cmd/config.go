@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect, check and edit the configuration file",
+	Long: `Inspect, check and edit the configuration file. Edits keep comments,
+blank lines and anchors of the file, except in the changed entries`,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check the configuration file for mistakes",
+	Long: `Check the configuration file against the schema of all settings, channel
+types, watermark options and setters known to this build. Problems are
+printed as file:line:column, and the command exits with 1 if any is found.
+The config file in use is checked if no file is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fn := viper.ConfigFileUsed()
+		if len(args) > 0 {
+			fn = args[0]
+		}
+		if fn == "" {
+			logrus.Errorln("no config file found, specify one to validate")
+			os.Exit(2)
+		}
+		problems, err := validateConfigFile(fn)
+		if err != nil {
+			logrus.WithError(err).WithField("file", fn).Errorln("cannot validate config file")
+			os.Exit(2)
+		}
+		for _, p := range problems {
+			fmt.Printf("%s:%s\n", fn, p)
+		}
+		if len(problems) > 0 {
+			logrus.WithField("file", fn).Errorf("%d problems found", len(problems))
+			os.Exit(1)
+		}
+		logrus.WithField("file", fn).Infoln("config file is valid")
+	},
+}
+
+var schemaOutput string
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Write JSON Schema of the configuration file",
+	Long: `Write JSON Schema of the configuration file to stdout or a file, for
+autocompletion and checking in editors supporting YAML schemas`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		bs, err := json.MarshalIndent(configSchema(), "", "  ")
+		if err != nil {
+			logrus.WithError(err).Errorln("cannot encode schema")
+			os.Exit(1)
+		}
+		bs = append(bs, '\n')
+		if schemaOutput == "" {
+			_, _ = os.Stdout.Write(bs)
+			return
+		}
+		if err := os.WriteFile(schemaOutput, bs, 0644); err != nil {
+			logrus.WithError(err).WithField("file", schemaOutput).Errorln("cannot write schema")
+			os.Exit(1)
+		}
+		logrus.WithField("file", schemaOutput).Infoln("schema written")
+	},
+}
+
+func init() {
+	configSchemaCmd.Flags().StringVarP(&schemaOutput, "output", "o", "", "write the schema into the file instead of stdout")
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	RootCmd.AddCommand(configCmd)
+}
+
+// validateConfigFile checks the file against the schema, then checks
+// references and expressions the schema cannot express. Problems are
+// formatted as line:column: path: message
+func validateConfigFile(fn string) ([]string, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return validateConfigData(fn, data)
+}
+
+// validateConfigData checks content of the config file like
+// validateConfigFile. References are checked after merging includes and
+// overlays of the file
+func validateConfigData(fn string, data []byte) ([]string, error) {
+	schemaErrors, err := util.ValidateYAML(data, configSchema())
+	if err != nil {
+		return nil, err
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if tree, err := config.LoadTree(fn, data); err != nil {
+		schemaErrors = append(schemaErrors, configProblem(data, err.Error(), config.IncludeKey))
+		if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	} else if err := v.MergeConfigMap(tree.Settings); err != nil {
+		return nil, err
+	}
+	schemaErrors = append(schemaErrors, checkConfigReferences(data, v)...)
+	sort.SliceStable(schemaErrors, func(i, j int) bool {
+		return schemaErrors[i].Line < schemaErrors[j].Line
+	})
+
+	problems := make([]string, 0, len(schemaErrors))
+	for _, e := range schemaErrors {
+		problems = append(problems, e.Error())
+	}
+	return problems, nil
+}
+
+// configProblem reports a problem at the position of the path
+func configProblem(data []byte, message string, path ...interface{}) util.SchemaError {
+	line, column, _ := util.LocateYAML(data, path...)
+	p := ""
+	for _, item := range path {
+		switch key := item.(type) {
+		case int:
+			p += fmt.Sprintf("[%d]", key)
+		default:
+			if p != "" {
+				p += "."
+			}
+			p += fmt.Sprint(key)
+		}
+	}
+	return util.SchemaError{Path: p, Line: line, Column: column, Message: message}
+}
+
+// channelNames returns names in a channel list in the format of
+// active-channels, ignoring malformed items reported by the schema
+func channelNames(value interface{}) []string {
+	var names []string
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		switch ch := item.(type) {
+		case string:
+			names = append(names, ch)
+		case map[string]interface{}:
+			for name := range ch {
+				names = append(names, name)
+			}
+		default:
+			names = append(names, "")
+		}
+	}
+	return names
+}
+
+// checkConfigReferences checks channel names and expressions in the config
+func checkConfigReferences(data []byte, v *viper.Viper) []util.SchemaError {
+	var errs []util.SchemaError
+	defined := v.GetStringMap("channels")
+	checkNames := func(value interface{}, path ...interface{}) {
+		for idx, name := range channelNames(value) {
+			// viper keeps channel names in lower case
+			if _, ok := defined[strings.ToLower(name)]; name != "" && !ok {
+				itemPath := append(append([]interface{}{}, path...), idx)
+				errs = append(errs, configProblem(data, fmt.Sprintf("channel %s not defined", name), itemPath...))
+			}
+		}
+	}
+	checkNames(v.Get("active-channels"), "active-channels")
+	rules, _ := v.Get("channel-selection.rules").([]interface{})
+	for idx, rule := range rules {
+		if r, ok := rule.(map[string]interface{}); ok {
+			checkNames(r["channels"], "channel-selection", "rules", idx, "channels")
+		}
+	}
+	profiles := v.GetStringMap(config.ProfilesKey)
+	checkProfile := func(value interface{}, path ...interface{}) {
+		name, _ := value.(string)
+		if _, ok := profiles[strings.ToLower(name)]; name != "" && !ok {
+			errs = append(errs, configProblem(data, fmt.Sprintf("profile %s not defined", name), path...))
+		}
+	}
+	checkProfile(v.Get(config.ProfileKey), config.ProfileKey)
+	profileRules, _ := v.Get("profile-rules").([]interface{})
+	for idx, rule := range profileRules {
+		if r, ok := rule.(map[string]interface{}); ok {
+			checkProfile(r["profile"], "profile-rules", idx, "profile")
+		}
+	}
+	profileNames := make([]string, 0, len(profiles))
+	for name := range profiles {
+		profileNames = append(profileNames, name)
+	}
+	sort.Strings(profileNames)
+	for _, name := range profileNames {
+		p, _ := profiles[name].(map[string]interface{})
+		checkNames(p["active-channels"], config.ProfilesKey, name, "active-channels")
+		selection, _ := p["channel-selection"].(map[string]interface{})
+		rules, _ := selection["rules"].([]interface{})
+		for idx, rule := range rules {
+			if r, ok := rule.(map[string]interface{}); ok {
+				checkNames(r["channels"], config.ProfilesKey, name, "channel-selection", "rules", idx, "channels")
+			}
+		}
+	}
+
+	checkSchedule := func(value interface{}, path ...interface{}) {
+		_, isList := value.([]interface{})
+		for idx, expr := range scheduleExpressions(value) {
+			if _, err := util.ParseCron(expr); err != nil {
+				p := path
+				if isList {
+					p = append(append([]interface{}{}, path...), idx)
+				}
+				errs = append(errs, configProblem(data, err.Error(), p...))
+			}
+		}
+	}
+	checkSchedule(v.Get("daemon.schedule"), "daemon", "schedule")
+	names := make([]string, 0, len(defined))
+	for name := range defined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checkSchedule(v.Get("channels."+name+".schedule"), "channels", name, "schedule")
+	}
+
+	if alignTo := v.GetString("daemon.align-to"); alignTo != "" {
+		if _, err := parseClock(alignTo); err != nil {
+			errs = append(errs, configProblem(data, err.Error(), "daemon", "align-to"))
+		}
+	}
+
+	checkWatermarks := func(value interface{}, path ...interface{}) {
+		watermarks, _ := value.([]interface{})
+		for idx, item := range watermarks {
+			w, _ := item.(map[string]interface{})
+			text, ok := w["template"].(string)
+			if !ok {
+				continue
+			}
+			if _, err := template.New("watermark").Parse(text); err != nil {
+				p := append(append([]interface{}{}, path...), idx, "template")
+				errs = append(errs, configProblem(data, fmt.Sprintf("invalid template: %s", err), p...))
+			}
+		}
+	}
+	checkWatermarks(v.Get("watermark"), "watermark")
+	for _, name := range names {
+		checkWatermarks(v.Get("channels."+name+".watermark"), "channels", name, "watermark")
+	}
+	for _, name := range profileNames {
+		checkWatermarks(v.Get(config.ProfilesKey+"."+name+".watermark"), config.ProfilesKey, name, "watermark")
+	}
+	return errs
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+var (
+	dumpResolved    bool
+	dumpShowSecrets bool
+)
+
+var configDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Print the settings in effect",
+	Long: `Print the settings in effect, i.e. the config file merged with its includes,
+overlays, environment variables and defaults. Values of secret-like options
+are masked unless --show-secrets is given.
+
+With --resolved, files merged are listed in order, and each setting is
+followed by the file, environment variable or default it comes from.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		settings := util.Settings.AllSettings()
+		if !dumpShowSecrets {
+			settings = util.MaskSecrets(settings)
+		}
+		doc := &yamlv3.Node{Kind: yamlv3.DocumentNode, Content: []*yamlv3.Node{dumpNode(settings, "")}}
+		if t := config.LoadedTree(); dumpResolved && t != nil {
+			doc.HeadComment = "files merged in order:"
+			for _, fn := range t.Files {
+				doc.HeadComment += "\n  " + fn
+			}
+		}
+		encoder := yamlv3.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(doc); err != nil {
+			logrus.WithError(err).Errorln("cannot encode settings")
+			os.Exit(1)
+		}
+		_ = encoder.Close()
+	},
+}
+
+func init() {
+	configDumpCmd.Flags().BoolVar(&dumpResolved, "resolved", false, "show where each setting comes from")
+	configDumpCmd.Flags().BoolVar(&dumpShowSecrets, "show-secrets", false, "print secrets as they are")
+	configCmd.AddCommand(configDumpCmd)
+}
+
+// dumpNode encodes the settings under the flattened key prefix, with sorted
+// keys and sources of leaves if required. Empty mappings are leaves too
+func dumpNode(value interface{}, prefix string) *yamlv3.Node {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		node := &yamlv3.Node{}
+		if err := node.Encode(value); err != nil {
+			node.SetString(fmt.Sprint(value))
+		}
+		return node
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	node := &yamlv3.Node{Kind: yamlv3.MappingNode}
+	for _, key := range keys {
+		full := key
+		if prefix != "" {
+			full = prefix + "." + key
+		}
+		k := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}
+		v := dumpNode(m[key], full)
+		if dumpResolved && (v.Kind != yamlv3.MappingNode || len(v.Content) == 0) {
+			// comment the key, as comments of lists are printed after items
+			k.LineComment = config.Source(full)
+		}
+		node.Content = append(node.Content, k, v)
+	}
+	return node
+}
@@ -0,0 +1,432 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+var (
+	setAsString    bool
+	forceEdit      bool
+	newChannelType string
+	newChannelOpts []string
+	enableChannel  bool
+)
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print value of a setting",
+	Long: `Print value of a setting, with defaults applied. Nested keys are joined
+by dots, and list items are picked by indexes, e.g. watermark.0.color`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		value, ok := lookupSetting(util.Settings.AllSettings(), args[0])
+		if !ok {
+			logrus.WithField("key", args[0]).Errorln("setting not found")
+			os.Exit(1)
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			bs, err := yamlv3.Marshal(value)
+			if err != nil {
+				logrus.WithError(err).Errorln("cannot encode setting")
+				os.Exit(1)
+			}
+			_, _ = os.Stdout.Write(bs)
+		default:
+			fmt.Println(value)
+		}
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change value of a setting in the configuration file",
+	Long: `Change value of a setting in the configuration file. The value is read as
+YAML, so numbers, booleans, lists like [a, b] and null are stored with their
+types, unless --string is given`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var value interface{} = args[1]
+		if !setAsString {
+			value = parseValue(args[1])
+		}
+		modifyConfig(func(doc *config.Document) error {
+			return doc.Set(args[0], value)
+		})
+		logrus.WithField("key", args[0]).Infof("set to %v", value)
+	},
+}
+
+var configAddChannelCmd = &cobra.Command{
+	Use:   "add-channel <name>",
+	Short: "Define a new channel in the configuration file",
+	Long: `Define a new channel of the type in the configuration file, with options
+given as -o key=value, e.g.
+
+  goTApaper config add-channel nature --type unsplash -o query=nature -o key=KEY --enable`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if strings.Contains(name, ".") {
+			logrus.WithField("channel", name).Errorln("channel name cannot contain dots")
+			os.Exit(2)
+		}
+		if _, ok := channel.Channels.Get(newChannelType); !ok {
+			logrus.WithField("type", newChannelType).Errorf(
+				"unknown channel type, choose one of %s",
+				strings.Join(registryNames(channel.Channels.RegistryMap), ", "),
+			)
+			os.Exit(2)
+		}
+		if util.Settings.IsSet("channels." + name) {
+			logrus.WithField("channel", name).Errorln("channel already defined")
+			os.Exit(2)
+		}
+
+		options := map[string]interface{}{"type": newChannelType}
+		for _, option := range newChannelOpts {
+			key, value, ok := strings.Cut(option, "=")
+			if !ok || key == "" {
+				logrus.WithField("option", option).Errorln("option must be in key=value format")
+				os.Exit(2)
+			}
+			options[key] = parseValue(value)
+		}
+		key := ""
+		if enableChannel {
+			key, _ = activeChannels()
+		}
+		modifyConfig(func(doc *config.Document) error {
+			if err := doc.Set("channels."+name, options); err != nil {
+				return err
+			}
+			if enableChannel {
+				return doc.Append(key, name)
+			}
+			return nil
+		})
+		logrus.WithField("channel", name).Infoln("channel added")
+	},
+}
+
+var configEnableCmd = &cobra.Command{
+	Use:   "enable <channel> [probability]",
+	Short: "Add a channel to active-channels",
+	Long: `Add a defined channel to active-channels, optionally with the probability
+it is picked, or change the probability of an active channel`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if !util.Settings.IsSet("channels." + name) {
+			logrus.WithField("channel", name).Errorln("channel not defined")
+			os.Exit(2)
+		}
+		var item interface{} = name
+		if len(args) > 1 {
+			p, err := strconv.ParseFloat(args[1], 64)
+			if err != nil || p < 0 || p > 1 {
+				logrus.WithField("probability", args[1]).Errorln("probability must be a number within [0, 1]")
+				os.Exit(2)
+			}
+			item = map[string]interface{}{name: p}
+		}
+
+		key, items := activeChannels()
+		idx := activeIndex(items, name)
+		if idx >= 0 && len(args) == 1 {
+			logrus.WithField("channel", name).Infoln("channel already enabled")
+			return
+		}
+		modifyConfig(func(doc *config.Document) error {
+			if idx >= 0 {
+				return doc.Set(fmt.Sprintf("%s.%d", key, idx), item)
+			}
+			return doc.Append(key, item)
+		})
+		logrus.WithField("channel", name).Infoln("channel enabled")
+	},
+}
+
+var configDisableCmd = &cobra.Command{
+	Use:   "disable <channel>",
+	Short: "Remove a channel from active-channels",
+	Long:  `Remove a channel from active-channels, keeping its definition`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		key, items := activeChannels()
+		if activeIndex(items, name) < 0 {
+			logrus.WithField("channel", name).Infoln("channel not enabled")
+			return
+		}
+		modifyConfig(func(doc *config.Document) error {
+			_, err := doc.Remove(key, func(item interface{}) bool {
+				names := channelNames([]interface{}{item})
+				return len(names) == 1 && strings.EqualFold(names[0], name)
+			})
+			return err
+		})
+		logrus.WithField("channel", name).Infoln("channel disabled")
+	},
+}
+
+var configEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit the configuration file with an editor",
+	Long: `Open a copy of the configuration file with $VISUAL or $EDITOR, and check it
+after the editor exits. The file is changed only when the copy is valid or
+saving it anyway is confirmed`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fn := configFileUsed()
+		if err := editConfig(fn); err != nil {
+			logrus.WithError(err).WithField("file", fn).Errorln("cannot edit config file")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	configSetCmd.Flags().BoolVar(&setAsString, "string", false, "store the value as a string")
+	configAddChannelCmd.Flags().StringVarP(&newChannelType, "type", "t", "", "type of the channel")
+	configAddChannelCmd.Flags().StringArrayVarP(&newChannelOpts, "option", "o", nil, "option of the channel in key=value format, can be repeated")
+	configAddChannelCmd.Flags().BoolVar(&enableChannel, "enable", false, "add the channel to active-channels")
+	_ = configAddChannelCmd.MarkFlagRequired("type")
+	for _, c := range []*cobra.Command{configSetCmd, configAddChannelCmd, configEnableCmd, configDisableCmd} {
+		c.Flags().BoolVarP(&forceEdit, "force", "f", false, "save even if the change makes the config file invalid")
+	}
+
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configAddChannelCmd)
+	configCmd.AddCommand(configEnableCmd)
+	configCmd.AddCommand(configDisableCmd)
+	configCmd.AddCommand(configEditCmd)
+}
+
+// lookupSetting walks the settings by a dotted key, where numbers index
+// lists
+func lookupSetting(settings map[string]interface{}, key string) (interface{}, bool) {
+	var value interface{} = settings
+	for _, part := range strings.Split(strings.ToLower(key), ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			value = item
+		case []interface{}:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, false
+			}
+			value = v[idx]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// parseValue reads the command line argument as YAML, falling back to the
+// plain string if it is not valid YAML. Strings read as null, like "" or
+// "#ff0000" which is a comment, are kept unless they are null in YAML
+func parseValue(s string) interface{} {
+	var value interface{}
+	if err := yamlv3.Unmarshal([]byte(s), &value); err != nil {
+		return s
+	}
+	if value == nil {
+		switch strings.TrimSpace(s) {
+		case "null", "Null", "NULL", "~":
+		default:
+			return s
+		}
+	}
+	return value
+}
+
+// activeChannels returns the key of active channels to edit, which is the
+// one of the profile in effect if it overrides them, and the list under the
+// key in the config file. It fails if the list is set by an included file,
+// which editing the config file cannot change
+func activeChannels() (string, []interface{}) {
+	key := config.EditKey("active-channels")
+	fn := configFileUsed()
+	doc, err := config.LoadDocument(fn)
+	if err != nil {
+		logrus.WithError(err).WithField("file", fn).Errorln("cannot read config file")
+		os.Exit(2)
+	}
+	items, ok, err := doc.Items(key)
+	if err != nil {
+		logrus.WithError(err).WithField("file", fn).Errorln("cannot read active channels")
+		os.Exit(2)
+	}
+	if included := config.IncludedFrom(key); !ok && included != "" {
+		logrus.WithField("key", key).WithField("file", included).Errorln("active channels are set in an included file, edit that file instead")
+		os.Exit(1)
+	}
+	return key, items
+}
+
+// activeIndex returns position of the channel in the items, or -1
+func activeIndex(items []interface{}, name string) int {
+	for idx, active := range channelNames(items) {
+		if strings.EqualFold(active, name) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func configFileUsed() string {
+	fn := viper.ConfigFileUsed()
+	if fn == "" {
+		logrus.Errorln("no config file found, specify one with -c")
+		os.Exit(2)
+	}
+	return fn
+}
+
+// modifyConfig applies the edit to the config file in use. The change is
+// refused if it brings new problems to the file, unless forced
+func modifyConfig(edit func(doc *config.Document) error) {
+	fn := configFileUsed()
+	doc, err := config.LoadDocument(fn)
+	if err != nil {
+		logrus.WithError(err).WithField("file", fn).Errorln("cannot read config file")
+		os.Exit(2)
+	}
+	before, err := validateConfigData(fn, doc.Bytes())
+	if err != nil {
+		logrus.WithError(err).WithField("file", fn).Errorln("cannot validate config file")
+		os.Exit(2)
+	}
+	if err := edit(doc); err != nil {
+		logrus.WithError(err).WithField("file", fn).Errorln("cannot change config file")
+		os.Exit(1)
+	}
+
+	after, err := validateConfigData(fn, doc.Bytes())
+	if err != nil {
+		logrus.WithError(err).WithField("file", fn).Errorln("cannot validate changed config file")
+		os.Exit(1)
+	}
+	if len(after) > len(before) {
+		for _, p := range after {
+			fmt.Printf("%s:%s\n", fn, p)
+		}
+		if !forceEdit {
+			logrus.WithField("file", fn).Errorln("change refused as it makes the config file invalid, use --force to save anyway")
+			os.Exit(1)
+		}
+	}
+	if err := doc.Save(fn); err != nil {
+		logrus.WithError(err).WithField("file", fn).Errorln("cannot write config file")
+		os.Exit(1)
+	}
+}
+
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// editConfig lets the user edit a copy of the config file until it is valid,
+// or saving or discarding it is chosen
+func editConfig(fn string) error {
+	original, err := os.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "goTApaper-*"+filepath.Ext(fn))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(original)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	editor := editorCommand()
+	input := bufio.NewReader(os.Stdin)
+	for {
+		c := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+		c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("editor %s failed: %w", editor[0], err)
+		}
+		edited, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(edited, original) {
+			logrus.WithField("file", fn).Infoln("no changes made")
+			return nil
+		}
+
+		problems, err := validateConfigData(fn, edited)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		if len(problems) == 0 {
+			return saveEdited(fn, edited)
+		}
+		for _, p := range problems {
+			fmt.Printf("%s:%s\n", fn, p)
+		}
+		fmt.Print("[e]dit again, [s]ave anyway or [d]iscard changes? ")
+		answer, err := input.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "s", "save":
+			return saveEdited(fn, edited)
+		case "d", "discard":
+			logrus.WithField("file", fn).Infoln("changes discarded")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("no answer: %w", err)
+		}
+	}
+}
+
+func saveEdited(fn string, data []byte) error {
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(fn); err == nil {
+		mode = stat.Mode().Perm()
+	}
+	if err := os.WriteFile(fn, data, mode); err != nil {
+		return err
+	}
+	logrus.WithField("file", fn).Infoln("config file saved")
+	return nil
+}
@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/genzj/goTApaper/actor"
+	"github.com/genzj/goTApaper/actor/setter"
+	"github.com/genzj/goTApaper/actor/watermark"
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/util"
+)
+
+// schemaID identifies the schema exported by the config schema command
+const schemaID = "https://github.com/genzj/goTApaper/config.schema.json"
+
+func registryNames(m util.RegistryMap) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// object describes a mapping with known keys only, which can be left empty
+func object(properties util.Schema) util.Schema {
+	return util.Schema{
+		"type":                 []string{"object", "null"},
+		"additionalProperties": false,
+		"properties":           properties,
+	}
+}
+
+func typed(t interface{}, description string) util.Schema {
+	s := util.Schema{"type": t}
+	if description != "" {
+		s["description"] = description
+	}
+	return s
+}
+
+func seconds(description string) util.Schema {
+	s := typed("number", description)
+	s["minimum"] = 0
+	return s
+}
+
+// secretRefSchema describes a secret reference replacing a string option
+func secretRefSchema() util.Schema {
+	ref := typed("string", "")
+	return util.Schema{
+		"type":                 "object",
+		"minProperties":        1,
+		"maxProperties":        1,
+		"additionalProperties": false,
+		"properties": util.Schema{
+			util.SecretFile:    ref,
+			util.SecretEnv:     ref,
+			util.SecretCommand: ref,
+		},
+	}
+}
+
+// allowSecretRefs lets free text string options in the schema be secret
+// references as well. Items of lists are not options and kept as they are
+func allowSecretRefs(s util.Schema, option bool) util.Schema {
+	if t, ok := s["type"].(string); ok && option && t == "string" && s["enum"] == nil && s["const"] == nil {
+		return util.Schema{"anyOf": []util.Schema{s, secretRefSchema()}}
+	}
+	for _, keyword := range []string{"properties", "patternProperties"} {
+		if properties, ok := s[keyword].(util.Schema); ok {
+			for key, property := range properties {
+				if p, ok := property.(util.Schema); ok {
+					properties[key] = allowSecretRefs(p, true)
+				}
+			}
+		}
+	}
+	for keyword, isOption := range map[string]bool{"additionalProperties": true, "items": false, "then": false, "else": false} {
+		if sub, ok := s[keyword].(util.Schema); ok {
+			s[keyword] = allowSecretRefs(sub, isOption)
+		}
+	}
+	for _, keyword := range []string{"anyOf", "allOf"} {
+		if subs, ok := s[keyword].([]util.Schema); ok {
+			for idx := range subs {
+				subs[idx] = allowSecretRefs(subs[idx], false)
+			}
+		}
+	}
+	return s
+}
+
+func httpSchema() util.Schema {
+	return object(util.Schema{
+		"proxy":                typed("string", "direct, environment or URL of proxy server"),
+		"proxy-username":       typed("string", ""),
+		"proxy-password":       typed("string", ""),
+		"user-agent":           typed("string", ""),
+		"headers":              util.Schema{"type": "object", "additionalProperties": typed("string", "")},
+		"ca-bundle":            typed("string", "PEM file of extra trusted certificates"),
+		"insecure-skip-verify": typed("boolean", ""),
+	})
+}
+
+func retrySchema() util.Schema {
+	return object(util.Schema{
+		"attempts":      util.Schema{"type": "integer", "minimum": 1},
+		"initial-delay": seconds("seconds before the first retry"),
+		"multiplier":    util.Schema{"type": "number", "minimum": 1},
+		"max-delay":     seconds("upper limit of delay in seconds"),
+		"jitter":        util.Schema{"type": "number", "minimum": 0, "maximum": 1},
+	})
+}
+
+func filtersSchema() util.Schema {
+	ratio := util.Schema{"type": "number", "minimum": 0}
+	fraction := util.Schema{"type": "number", "minimum": 0, "maximum": 1}
+	keywords := util.Schema{"type": "array", "items": typed("string", "")}
+	return object(util.Schema{
+		"min-width":        util.Schema{"type": "integer", "minimum": 0},
+		"min-height":       util.Schema{"type": "integer", "minimum": 0},
+		"min-aspect-ratio": ratio,
+		"max-aspect-ratio": ratio,
+		"min-brightness":   fraction,
+		"min-contrast":     fraction,
+		"include-keywords": keywords,
+		"exclude-keywords": keywords,
+	})
+}
+
+func scheduleSchema() util.Schema {
+	cron := typed("string", "cron expression or descriptor like @daily")
+	return util.Schema{"anyOf": []util.Schema{cron, {"type": "array", "items": cron}}}
+}
+
+// channelListSchema describes active-channels and channels of time-of-day
+// rules, i.e. names optionally with probabilities, or weights for the
+// weighted-random policy
+func channelListSchema() util.Schema {
+	return util.Schema{
+		"type": "array",
+		"items": util.Schema{"anyOf": []util.Schema{
+			typed("string", "channel name"),
+			{
+				"type":                 "object",
+				"minProperties":        1,
+				"maxProperties":        1,
+				"additionalProperties": util.Schema{"type": "number", "minimum": 0},
+			},
+		}},
+	}
+}
+
+// renderOptions are global settings of cropping and rendering, which
+// channels can override
+func renderOptions() util.Schema {
+	return util.Schema{
+		actor.PipelineKey: util.Schema{
+			"type":        "array",
+			"description": "actors processing the picture in order",
+			"items":       util.Schema{"type": "string", "enum": registryNames(actor.Actors)},
+		},
+		"crop":             util.Schema{"type": "string", "enum": []string{"yes", "no", "win-only"}},
+		"reference-width":  util.Schema{"type": "number", "exclusiveMinimum": 0},
+		"reference-height": util.Schema{"type": "number", "exclusiveMinimum": 0},
+		"watermark":        util.Schema{"type": []string{"array", "null"}, "items": watermark.Schema()},
+	}
+}
+
+// commonChannelOptions are understood by all channels
+func commonChannelOptions() util.Schema {
+	options := util.Schema{
+		"type":     typed("string", "channel type"),
+		"timeout":  seconds("seconds allowed to download, 0 means no limit"),
+		"retry":    retrySchema(),
+		"http":     httpSchema(),
+		"schedule": scheduleSchema(),
+		"filters":  filtersSchema(),
+		"watermark-mode": util.Schema{
+			"type":        "string",
+			"enum":        []string{actor.WatermarkReplace, actor.WatermarkAppend},
+			"description": "whether watermarks of the channel replace or follow the global ones",
+		},
+	}
+	for key, option := range renderOptions() {
+		options[key] = option
+	}
+	return options
+}
+
+// channelSchema checks options of each registered channel type by the type
+// option of the definition
+func channelSchema() util.Schema {
+	types := registryNames(channel.Channels.RegistryMap)
+	var byType []util.Schema
+	for _, name := range types {
+		properties := commonChannelOptions()
+		v, _ := channel.Channels.Get(name)
+		describer, ok := v.(channel.OptionsDescriber)
+		if !ok {
+			// options unknown, accept anything
+			continue
+		}
+		for key, option := range describer.Options() {
+			properties[key] = option
+		}
+		byType = append(byType, util.Schema{
+			"if": util.Schema{
+				"required":   []string{"type"},
+				"properties": util.Schema{"type": util.Schema{"const": name}},
+			},
+			"then": object(properties),
+		})
+	}
+	return util.Schema{
+		"type":       "object",
+		"required":   []string{"type"},
+		"properties": util.Schema{"type": util.Schema{"type": "string", "enum": types}},
+		"allOf":      byType,
+	}
+}
+
+func selectionSchema() util.Schema {
+	policies := registryNames(Selectors)
+	return object(util.Schema{
+		"policy": util.Schema{"type": "string", "enum": policies},
+		"rules": util.Schema{
+			"type": "array",
+			"items": object(util.Schema{
+				"hours":    util.Schema{"type": "string", "pattern": `^\s*\d+\s*-\s*\d+\s*$`},
+				"weekdays": util.Schema{"type": "array", "items": typed("string", "")},
+				"channels": channelListSchema(),
+				"policy":   util.Schema{"type": "string", "enum": policies},
+			}),
+		},
+	})
+}
+
+// configSchema describes the whole config file in JSON Schema, with channel
+// types, setters and selection policies registered in this build
+func configSchema() util.Schema {
+	schema := util.Schema{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         schemaID,
+		"title":       "goTApaper configuration",
+		"type":        []string{"object", "null"},
+		"description": "keys ending with -settings are free-form, e.g. to hold anchors merged into channels",
+		"patternProperties": util.Schema{
+			"-settings$": util.Schema{},
+		},
+		"additionalProperties": false,
+		"properties": util.Schema{
+			config.IncludeKey: util.Schema{
+				"description": "files or glob patterns merged under this file, relative to it",
+				"anyOf":       []util.Schema{typed("string", ""), {"type": "array", "items": typed("string", "")}},
+			},
+			"wallpaper-file-name": typed("string", "path and basename of the wallpaper, without extension"),
+			"history-file":        typed("string", ""),
+			"health-file":         typed("string", ""),
+			"favourites-dir":      typed("string", ""),
+			"language":            typed("string", "in xx-YY format"),
+			"debug":               typed("boolean", ""),
+			"debug-rendering":     typed("boolean", ""),
+			"proxy":               typed("string", "direct, environment or URL of proxy server"),
+			"http":                httpSchema(),
+			"refresh-timeout":     seconds("seconds allowed for a whole refresh, 0 means no limit"),
+			"channel-timeout":     seconds("seconds allowed for a channel, 0 means no limit"),
+			"retry":               retrySchema(),
+			"download": object(util.Schema{
+				"max-size":   util.Schema{"type": "integer", "minimum": 0},
+				"max-pixels": util.Schema{"type": "integer", "minimum": 0},
+			}),
+			"http-cache": object(util.Schema{
+				"enabled":          typed("boolean", ""),
+				"dir":              typed("string", ""),
+				"max-size":         util.Schema{"type": "integer", "minimum": 0},
+				"offline-fallback": typed("boolean", ""),
+			}),
+			"health": object(util.Schema{
+				"threshold":      util.Schema{"type": "integer", "minimum": 0},
+				"base-cool-down": seconds(""),
+				"max-cool-down":  seconds(""),
+			}),
+			"prefetch": object(util.Schema{
+				"enabled":     typed("boolean", ""),
+				"dir":         typed("string", ""),
+				"size":        util.Schema{"type": "integer", "minimum": 0},
+				"concurrency": util.Schema{"type": "integer", "minimum": 1},
+				"max-age":     seconds(""),
+			}),
+			"filters": filtersSchema(),
+			"api": object(util.Schema{
+				"enabled": typed("boolean", ""),
+				"listen":  typed("string", "loopback address and port"),
+				"token":   typed("string", ""),
+			}),
+			"archive": object(util.Schema{
+				"size": util.Schema{"type": "integer", "minimum": 0},
+				"dir":  typed("string", ""),
+			}),
+			"daemon": object(util.Schema{
+				"interval": util.Schema{"type": "integer", "minimum": 1},
+				"align-to": util.Schema{"type": "string", "pattern": `^\d{1,2}:\d{2}(:\d{2})?$`},
+				"schedule": scheduleSchema(),
+				"network-probe": object(util.Schema{
+					"url":      typed("string", "empty to disable"),
+					"interval": seconds(""),
+					"max-wait": seconds(""),
+				}),
+			}),
+			"setter":            util.Schema{"type": "string", "enum": registryNames(setter.Setters)},
+			"active-channels":   channelListSchema(),
+			"channel-selection": selectionSchema(),
+			"channels": util.Schema{
+				"type":                 "object",
+				"additionalProperties": channelSchema(),
+			},
+			config.ProfileKey: typed("string", "profile used unless chosen otherwise"),
+			"profile-rules": util.Schema{
+				"type": "array",
+				"items": object(util.Schema{
+					"hours":    util.Schema{"type": "string", "pattern": `^\s*\d+\s*-\s*\d+\s*$`},
+					"weekdays": util.Schema{"type": "array", "items": typed("string", "")},
+					"profile":  typed("string", ""),
+				}),
+			},
+		},
+	}
+	properties := schema["properties"].(util.Schema)
+	for key, option := range renderOptions() {
+		properties[key] = option
+	}
+	addProfilesSchema(properties)
+	return allowSecretRefs(schema, false)
+}
+
+// addProfilesSchema describes profiles, whose settings are checked the same
+// way as the top level ones they override
+func addProfilesSchema(properties util.Schema) {
+	overrides := util.Schema{}
+	for _, key := range config.ProfileKeys {
+		overrides[key] = properties[key]
+	}
+	properties[config.ProfilesKey] = util.Schema{
+		"type":                 "object",
+		"description":          "named profiles overriding " + strings.Join(config.ProfileKeys, ", "),
+		"additionalProperties": object(overrides),
+	}
+}
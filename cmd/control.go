@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/genzj/goTApaper/actor/setter"
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	controlRefresh = "refresh"
+	controlNext    = "next"
+	controlPause   = "pause"
+	controlResume  = "resume"
+	controlStatus  = "status"
+	controlProfile = "profile"
+
+	// controlTimeout limits a whole conversation on the control socket
+	controlTimeout = 10 * time.Second
+)
+
+var errNoDaemon = errors.New("no running daemon")
+
+// controlRequest is sent by commands to the running daemon, one JSON object
+// per connection
+type controlRequest struct {
+	Command  string
+	Channels []string `json:",omitempty"`
+	Force    bool     `json:",omitempty"`
+	// Profile to select, empty to choose the profile automatically
+	Profile string `json:",omitempty"`
+	// Setter and Timeout override the config of the daemon in the refresh
+	Setter  string  `json:",omitempty"`
+	Timeout *uint32 `json:",omitempty"`
+}
+
+type controlResponse struct {
+	OK     bool
+	Error  string        `json:",omitempty"`
+	Status *DaemonStatus `json:",omitempty"`
+}
+
+// DaemonStatus describes the running daemon
+type DaemonStatus struct {
+	PID         int
+	StartedAt   time.Time
+	Paused      bool
+	NextCycle   time.Time
+	LastRefresh time.Time            `json:",omitempty"`
+	LastMeta    *channel.PictureMeta `json:",omitempty"`
+	LastError   string               `json:",omitempty"`
+	Profile     string               `json:",omitempty"`
+}
+
+// daemonState is shared by the daemon loop and the control socket
+type daemonState struct {
+	l      sync.Mutex
+	status DaemonStatus
+}
+
+var currentDaemon = &daemonState{
+	status: DaemonStatus{PID: os.Getpid(), StartedAt: time.Now()},
+}
+
+func (d *daemonState) snapshot() DaemonStatus {
+	d.l.Lock()
+	defer d.l.Unlock()
+	status := d.status
+	status.Profile = config.ActiveProfile()
+	return status
+}
+
+func (d *daemonState) isPaused() bool {
+	d.l.Lock()
+	defer d.l.Unlock()
+	return d.status.Paused
+}
+
+func (d *daemonState) setPaused(paused bool) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	d.status.Paused = paused
+}
+
+func (d *daemonState) setNextCycle(at time.Time) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	d.status.NextCycle = at
+}
+
+func (d *daemonState) setResult(meta *channel.PictureMeta, err error) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	d.status.LastRefresh = time.Now()
+	if meta != nil {
+		d.status.LastMeta = meta
+	}
+	d.status.LastError = ""
+	if err != nil {
+		d.status.LastError = err.Error()
+	}
+}
+
+type forceKey struct{}
+
+// withForce makes the refresh ignore history, as the --force flag does
+func withForce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey{}, true)
+}
+
+func forced(ctx context.Context) bool {
+	v, _ := ctx.Value(forceKey{}).(bool)
+	return v
+}
+
+type setterKey struct{}
+
+// withSetter makes the refresh use the named setter, as the --setter flag
+// does
+func withSetter(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, setterKey{}, name)
+}
+
+func requestedSetter(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(setterKey{}).(string)
+	return v, ok
+}
+
+type timeoutKey struct{}
+
+// withTimeout limits the refresh to the given seconds, as the --timeout flag
+// does
+func withTimeout(ctx context.Context, seconds uint32) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, seconds)
+}
+
+func requestedTimeout(ctx context.Context) (uint32, bool) {
+	v, ok := ctx.Value(timeoutKey{}).(uint32)
+	return v, ok
+}
+
+// requestCycle queues a refresh for the daemon loop, which runs queued
+// refreshes one by one after the one in progress
+func requestCycle(ctx context.Context, nextCycleCh nextCycleChannel, req cycleRequest) error {
+	if ctx.Err() != nil {
+		return errors.New("daemon is quitting")
+	}
+	select {
+	case nextCycleCh <- req:
+		return nil
+	default:
+		return fmt.Errorf("%d refreshes already queued, try again later", cap(nextCycleCh))
+	}
+}
+
+// serveControl accepts commands on the control socket until ctx is done.
+// The caller must hold the instance lock, so any existing socket file is
+// left by a dead daemon
+func serveControl(ctx context.Context, nextCycleCh nextCycleChannel) {
+	name := config.GetControlSocketName()
+	_ = os.Remove(name)
+	listener, err := net.Listen("unix", name)
+	if err != nil {
+		logrus.WithError(err).WithField("socket", name).Warn("cannot listen on control socket")
+		return
+	}
+	if err := os.Chmod(name, 0600); err != nil {
+		logrus.WithError(err).WithField("socket", name).Warn("cannot restrict access to control socket")
+	}
+	logrus.WithField("socket", name).Debug("control socket ready")
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+		_ = os.Remove(name)
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			} else if err != nil {
+				logrus.WithError(err).Warn("cannot accept control connection")
+				continue
+			}
+			go handleControl(ctx, conn, nextCycleCh)
+		}
+	}()
+}
+
+func handleControl(ctx context.Context, conn net.Conn, nextCycleCh nextCycleChannel) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	req := controlRequest{}
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		logrus.WithError(err).Warn("invalid control request")
+		return
+	}
+	l := logrus.WithField("command", req.Command)
+	l.Debug("control request received")
+
+	resp := controlResponse{OK: true}
+	switch req.Command {
+	case controlRefresh, controlNext:
+		if _, ok := setter.Setters.Get(req.Setter); req.Setter != "" && !ok {
+			resp = controlResponse{Error: fmt.Sprintf("setter \"%s\" not registered", req.Setter)}
+		} else if err := requestCycle(ctx, nextCycleCh, cycleRequest{
+			channels: req.Channels, force: req.Force, setter: req.Setter, timeout: req.Timeout,
+		}); err != nil {
+			resp = controlResponse{Error: err.Error()}
+		}
+	case controlPause:
+		currentDaemon.setPaused(true)
+		l.Info("daemon paused")
+	case controlResume:
+		currentDaemon.setPaused(false)
+		l.Info("daemon resumed")
+	case controlProfile:
+		if err := config.SelectProfile(req.Profile); err != nil {
+			resp = controlResponse{Error: err.Error()}
+		}
+	case controlStatus:
+	default:
+		resp = controlResponse{Error: fmt.Sprintf("unknown command %s", req.Command)}
+	}
+	status := currentDaemon.snapshot()
+	resp.Status = &status
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		l.WithError(err).Warn("cannot reply control request")
+	}
+}
+
+// sendControl sends the request to the running daemon. errNoDaemon is
+// returned if no daemon listens on the control socket
+func sendControl(req controlRequest) (*controlResponse, error) {
+	conn, err := net.DialTimeout("unix", config.GetControlSocketName(), time.Second)
+	if err != nil {
+		logrus.WithError(err).Debug("cannot connect to control socket")
+		return nil, errNoDaemon
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	resp := &controlResponse{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// forwardToDaemon sends the request to the running daemon if any. It
+// returns false if no daemon is running so the caller should do the work
+func forwardToDaemon(req controlRequest) bool {
+	_, err := sendControl(req)
+	if errors.Is(err, errNoDaemon) {
+		return false
+	} else if err != nil {
+		logrus.WithError(err).Errorf("daemon failed to %s", req.Command)
+		os.Exit(1)
+	}
+	logrus.Infof("%s request sent to the running daemon", req.Command)
+	return true
+}
+
+var nextCmd = &cobra.Command{
+	Use:   "next",
+	Short: "Switch to the next wallpaper",
+	Long: `Switch to the next wallpaper, using a prefetched picture if there is one.
+The running daemon does the work if any.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if forwardToDaemon(controlRequest{Command: controlNext}) {
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		refresh(ctx, nil)
+	},
+}
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause scheduled refreshes of the running daemon",
+	Long: `Pause scheduled refreshes of the running daemon until resumed. Refreshes
+requested explicitly, e.g. by the refresh command, still happen.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !forwardToDaemon(controlRequest{Command: controlPause}) {
+			logrus.WithError(errNoDaemon).Errorln("cannot pause")
+			os.Exit(1)
+		}
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume scheduled refreshes of the running daemon",
+	Long:  `Resume scheduled refreshes of the running daemon`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !forwardToDaemon(controlRequest{Command: controlResume}) {
+			logrus.WithError(errNoDaemon).Errorln("cannot resume")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(nextCmd)
+	RootCmd.AddCommand(pauseCmd)
+	RootCmd.AddCommand(resumeCmd)
+}
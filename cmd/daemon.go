@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"path"
+	"sync"
 	"time"
 
 	"github.com/genzj/goTApaper/channel"
 	"github.com/genzj/goTApaper/config"
-	"github.com/getlantern/systray"
+	"github.com/genzj/goTApaper/util"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -26,59 +30,173 @@ const (
 )
 
 type cycleUpdateCallback func(int, *channel.PictureMeta, error)
-type nextCycleChannel = chan []string
-type nextCycleWaitChannel = <-chan []string
+type nextCycleChannel = chan cycleRequest
+type nextCycleWaitChannel = <-chan cycleRequest
 type nextCycleTrigger func(channels []string)
 
+// cycleRequest asks the daemon to refresh before the schedule, or only to
+// compute the schedule again if reschedule is set
+type cycleRequest struct {
+	channels   []string
+	force      bool
+	reschedule bool
+	// setter and timeout given to refresh, empty to use the config
+	setter  string
+	timeout *uint32
+}
+
+// newNextCycleChannel queues refresh requests arriving while the daemon is
+// busy refreshing
+func newNextCycleChannel() nextCycleChannel {
+	return make(nextCycleChannel, cycleQueueSize)
+}
+
+const (
+	// cycleQueueSize limits refresh requests waiting for the daemon loop
+	cycleQueueSize = 8
+
+	// configSettleDelay merges config changes, e.g. several keys changed in
+	// one reload, into one refresh
+	configSettleDelay = 300 * time.Millisecond
+)
+
+// scheduleKeys only affect when to refresh, so changing them reschedules the
+// daemon instead of refreshing
+var scheduleKeys = []string{"daemon.*", "channels.*.schedule", "debug"}
+
+var daemonHeadless bool
+
 func init() {
+	daemonCmd.Flags().BoolVar(&daemonHeadless, "headless", false, "run without systray, controlled by signals")
 	daemonCmd.PersistentFlags().Uint32P("interval", "i", config.DefaultDaemonInterval, "interval between two refreshes")
 	viper.BindPFlag("daemon.interval", daemonCmd.PersistentFlags().Lookup("interval"))
 	RootCmd.AddCommand(daemonCmd)
 }
 
-func initDaemon(nextCycleCh nextCycleWaitChannel, callback cycleUpdateCallback) {
+// daemonCtx is cancelled when the daemon quits to abort in-flight downloads
+var daemonCtx, daemonCancel = context.WithCancel(context.Background())
+
+// initDaemon starts the refresh loop in background. The returned channel is
+// closed once the loop stops
+func initDaemon(ctx context.Context, nextCycleCh nextCycleWaitChannel, callback cycleUpdateCallback) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
-		var channels []string = nil
+		defer close(done)
 		for {
-			interval := viper.GetInt("daemon.interval")
-			logrus.WithField("interval", interval).Debug("refresh over, going to sleep")
-			select {
-			case <-time.After(time.Duration(interval) * time.Second):
-				logrus.WithField("interval", interval).Debug("awake from sleep")
-				channels = nil
-			case channels = <-nextCycleCh:
-				logrus.Debug("trigger next cycle before interval timeout")
+			next := nextCycleAt(time.Now().Round(0))
+			logrus.WithField("next", next.at).WithField("channels", next.channels).Debug("refresh over, going to sleep")
+			currentDaemon.setNextCycle(next.at)
+			next, req, triggered, err := sleepUntilDue(ctx, next, nextCycleCh)
+			if err != nil {
+				logrus.WithError(err).Debug("daemon loop stopped")
+				return
+			}
+			cycleCtx, channels := ctx, req.channels
+			if triggered && req.reschedule {
+				logrus.Debug("rescheduling after config change")
+				continue
+			} else if triggered {
+				logrus.Debug("trigger next cycle before schedule")
+				if req.force {
+					cycleCtx = withForce(cycleCtx)
+				}
+				if req.setter != "" {
+					cycleCtx = withSetter(cycleCtx, req.setter)
+				}
+				if req.timeout != nil {
+					cycleCtx = withTimeout(cycleCtx, *req.timeout)
+				}
+			} else if currentDaemon.isPaused() {
+				logrus.Info("daemon paused, scheduled refresh skipped")
+				continue
+			} else {
+				logrus.WithField("global", next.global).Debug("awake from sleep")
+				if next.global {
+					cycleCtx = withDueChannels(ctx, next.channels)
+				} else if channels = next.channels; len(channels) == 0 {
+					continue
+				}
+			}
+			waitForNetwork(ctx)
+			if ctx.Err() != nil {
+				continue
 			}
 			callback(stagePreRefresh, nil, nil)
-			meta, err := refresh(channels)
+			meta, err := refresh(cycleCtx, channels)
 			callback(stagePostRefresh, meta, err)
+			currentDaemon.setResult(meta, err)
+			// get pictures of following cycles ready in background
+			go defaultPrefetcher.refill(ctx)
 		}
 	}()
+	return done
 }
 
-func onReady() {
-	//api.StartApiServer()
-	nextCycleCh := make(nextCycleChannel)
-
+// newNextCycleTrigger returns a trigger sending to the channel. Config
+// changes also trigger a refresh, or a reschedule if only schedule keys
+// change
+func newNextCycleTrigger(nextCycleCh nextCycleChannel) nextCycleTrigger {
+	send := func(req cycleRequest) {
+		select {
+		case nextCycleCh <- req:
+		case <-daemonCtx.Done():
+		}
+	}
 	nextCycle := func(channels []string) {
-		nextCycleCh <- channels
+		send(cycleRequest{channels: channels})
 	}
 
+	var l sync.Mutex
+	var timer *time.Timer
+	refreshNeeded := false
 	config.Observe("*", func(key string, old, new interface{}) {
-		logrus.WithField("key", key).WithField("old", old).WithField("new", new).Debug("config change triggers refresh")
-		nextCycle(nil)
+		if util.IsSecretKey(key) {
+			old, new = util.MaskedValue, util.MaskedValue
+		}
+		logrus.WithField("key", key).WithField("old", old).WithField("new", new).Debug("config change received")
+		l.Lock()
+		defer l.Unlock()
+		refreshNeeded = refreshNeeded || !matchAny(scheduleKeys, key)
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(configSettleDelay, func() {
+			l.Lock()
+			req := cycleRequest{reschedule: !refreshNeeded}
+			refreshNeeded = false
+			l.Unlock()
+			logrus.WithField("reschedule", req.reschedule).Debug("config change triggers next cycle")
+			send(req)
+		})
 	})
-
-	callback := initSystray(nextCycle)
-	initDaemon(nextCycleWaitChannel(nextCycleCh), callback)
-	nextCycle(nil)
+	return nextCycle
 }
 
-func onExit() {
-	// clean up here
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
 }
 
 func daemon() {
-	logrus.Infoln("starting daemon...")
-	systray.Run(onReady, onExit)
+	config.EnsureAppDir()
+	lock, err := util.AcquireLock(config.GetInstanceLockFileName())
+	if err != nil {
+		logrus.WithError(err).Errorln("cannot start daemon, is another instance running?")
+		os.Exit(1)
+	}
+	defer lock.Release()
+	config.WatchConfig()
+	defaultPrefetcher.clearOnChange()
+	go scheduleProfiles(daemonCtx)
+
+	if daemonHeadless {
+		logrus.Infoln("starting headless daemon...")
+		headless()
+		return
+	}
+	runSystray()
 }
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// shutdownSignals stop the headless daemon gracefully
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// logCycle reports refresh results of the headless daemon, which has no
+// other place to show them
+func logCycle(stage int, meta *channel.PictureMeta, err error) {
+	if stage != stagePostRefresh {
+		logrus.Debug("refreshing...")
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Warn("refresh failed")
+	} else if meta != nil {
+		logrus.WithField("channel", meta.ChannelKey).WithField("title", meta.Title).Info("wallpaper refreshed")
+	}
+}
+
+// reloadConfig rereads the config file, and applies changes at once. Unlike
+// refresh, a broken file is reported without stopping the daemon
+func reloadConfig() {
+	if err := config.Reload(); err != nil {
+		logrus.WithError(err).WithField("CfgFile", viper.ConfigFileUsed()).Error("cannot reload config file")
+		return
+	}
+	logrus.WithField("CfgFile", viper.ConfigFileUsed()).Info("config reloaded")
+}
+
+// headless runs the daemon loop without systray until a shutdown signal
+// arrives. See reloadSignals and refreshSignals for other signals handled
+func headless() {
+	nextCycleCh := newNextCycleChannel()
+	nextCycle := newNextCycleTrigger(nextCycleCh)
+
+	signals := make(chan os.Signal, 1)
+	handled := append(append(append([]os.Signal{}, shutdownSignals...), reloadSignals...), refreshSignals...)
+	signal.Notify(signals, handled...)
+	defer signal.Stop(signals)
+
+	done := initDaemon(daemonCtx, nextCycleWaitChannel(nextCycleCh), logCycle)
+	serveControl(daemonCtx, nextCycleCh)
+	startAPI(daemonCtx, nextCycleCh)
+	go nextCycle(nil)
+
+	for {
+		select {
+		case <-done:
+			return
+		case sig := <-signals:
+			l := logrus.WithField("signal", sig)
+			switch {
+			case signalIn(sig, reloadSignals):
+				l.Info("reloading config")
+				reloadConfig()
+			case signalIn(sig, refreshSignals):
+				l.Info("refreshing now")
+				go nextCycle(nil)
+			default:
+				l.Info("shutting down")
+				daemonCancel()
+				// wait for the in-flight refresh to abort
+				<-done
+				return
+			}
+		}
+	}
+}
+
+func signalIn(sig os.Signal, signals []os.Signal) bool {
+	for _, s := range signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
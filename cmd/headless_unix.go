@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals reload the config file of the headless daemon
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// refreshSignals trigger a refresh of the headless daemon immediately
+var refreshSignals = []os.Signal{syscall.SIGUSR1}
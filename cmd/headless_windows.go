@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import "os"
+
+// Windows has no SIGHUP or SIGUSR1, the headless daemon can only be stopped
+var reloadSignals []os.Signal
+var refreshSignals []os.Signal
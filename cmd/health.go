@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/genzj/goTApaper/actor"
+	"github.com/genzj/goTApaper/history"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+)
+
+func loadCoolDownPolicy() history.CoolDownPolicy {
+	policy := history.CoolDownPolicy{}
+	if err := util.Settings.UnmarshalKey("health", &policy); err != nil {
+		logrus.WithError(err).Warn("cannot parse health settings")
+	}
+	return policy
+}
+
+// isCoolingDown tells whether a channel should be skipped due to recent
+// failures
+func isCoolingDown(name string) bool {
+	h, err := history.JSONHealthManagerSingleton.Load(name)
+	if err != nil {
+		// don't block the channel if health is unknown
+		return false
+	}
+	if h.CoolingDown(time.Now()) {
+		logrus.WithField("channel", name).WithField(
+			"failures", h.ConsecutiveFailures,
+		).WithField(
+			"until", h.CoolDownUntil.Local(),
+		).Info("skipped due to cooling down")
+		return true
+	}
+	return false
+}
+
+// recordHealth saves the result of a channel download
+func recordHealth(name string, err error) {
+	var saveErr error
+	switch {
+	case errors.Is(err, context.Canceled):
+		// aborted by user, not the channel's fault
+		return
+	case errors.Is(err, actor.ErrRejected):
+		// the channel works, just the picture is not wanted
+		return
+	case err != nil:
+		_, saveErr = history.JSONHealthManagerSingleton.RecordFailure(name, err, loadCoolDownPolicy())
+	default:
+		_, saveErr = history.JSONHealthManagerSingleton.RecordSuccess(name)
+	}
+	if saveErr != nil {
+		logrus.WithField("channel", name).WithError(saveErr).Warn("cannot save channel health")
+	}
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+)
+
+// probeNetwork tells whether the probe URL responds. Any HTTP status counts,
+// since a response proves the connectivity
+func probeNetwork(ctx context.Context, url string) bool {
+	interval := time.Duration(util.Settings.GetInt("daemon.network-probe.interval")) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, interval)
+	defer cancel()
+
+	client, err := util.NewClient(util.LoadHTTPSettings(nil))
+	if err != nil {
+		logrus.WithError(err).Warn("cannot initiate http client to probe network")
+		return false
+	}
+	resp, err := util.Probe(util.WithClient(ctx, client), url)
+	if err != nil {
+		logrus.WithError(err).Debug("network probe failed")
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode > 0 && resp.StatusCode != http.StatusProxyAuthRequired
+}
+
+// waitForNetwork blocks until the network probe succeeds, gives up after
+// daemon.network-probe.max-wait seconds and lets channels try anyway
+func waitForNetwork(ctx context.Context) {
+	url := util.Settings.GetString("daemon.network-probe.url")
+	if url == "" || replayDir != "" {
+		return
+	}
+	interval := time.Duration(util.Settings.GetInt("daemon.network-probe.interval")) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	maxWait := time.Duration(util.Settings.GetInt("daemon.network-probe.max-wait")) * time.Second
+	deadline := time.Now().Add(maxWait)
+
+	l := logrus.WithField("url", url)
+	for {
+		start := time.Now()
+		if probeNetwork(ctx, url) {
+			l.Debug("network is available")
+			return
+		}
+		if !time.Now().Before(deadline) {
+			l.WithField("waited", maxWait).Warn("network is still unavailable, refresh anyway")
+			return
+		}
+		l.Info("network is unavailable, waiting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval - time.Since(start)):
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/genzj/goTApaper/actor"
+	"github.com/genzj/goTApaper/actor/setter"
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/history"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+)
+
+const prefetchItemSuffix = ".json"
+
+// prefetchedItem is a rendered picture waiting in the ready queue
+type prefetchedItem struct {
+	Meta      *channel.PictureMeta
+	File      string
+	CreatedAt time.Time
+
+	// path of the item description file
+	path string
+}
+
+// prefetcher downloads and renders pictures ahead of time so that a refresh
+// can apply one immediately. The queue is kept on disk so it survives
+// restarts
+type prefetcher struct {
+	l       sync.Mutex
+	filling int32
+	// generation increases when the queue is cleared, so that pictures
+	// downloaded with old settings are not queued afterwards
+	generation int32
+}
+
+var defaultPrefetcher = &prefetcher{}
+
+// prefetchKeys are settings deciding which pictures are prefetched and how
+// they are rendered. The queue is cleared when any of them changes
+var prefetchKeys = append([]string{"active-channels", "channels.*", config.ProfileKey}, actor.RenderKeys...)
+
+func prefetchEnabled() bool {
+	return util.Settings.GetBool("prefetch.enabled") && util.Settings.GetInt("prefetch.size") > 0
+}
+
+// list returns queued items from the oldest to the newest, expired or broken
+// items are removed
+func (p *prefetcher) list() []prefetchedItem {
+	dir := config.GetPrefetchDir()
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+prefetchItemSuffix))
+	if err != nil {
+		logrus.WithError(err).Warn("cannot list prefetched pictures")
+		return nil
+	}
+
+	maxAge := time.Duration(util.Settings.GetInt("prefetch.max-age")) * time.Second
+	var items []prefetchedItem
+	for _, path := range matches {
+		l := logrus.WithField("item", path)
+		item := prefetchedItem{path: path}
+		bs, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(bs, &item)
+		}
+		if err == nil {
+			_, err = os.Stat(item.File)
+		}
+		if err != nil {
+			l.WithError(err).Warn("broken prefetched picture removed")
+			p.remove(item)
+			continue
+		}
+		if maxAge > 0 && time.Since(item.CreatedAt) > maxAge {
+			l.Info("expired prefetched picture removed")
+			p.remove(item)
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CreatedAt.Before(items[j].CreatedAt)
+	})
+	return items
+}
+
+func (p *prefetcher) remove(item prefetchedItem) {
+	if item.File != "" {
+		_ = os.Remove(item.File)
+	}
+	_ = os.Remove(item.path)
+}
+
+// clear removes all queued pictures
+func (p *prefetcher) clear() {
+	p.l.Lock()
+	defer p.l.Unlock()
+	atomic.AddInt32(&p.generation, 1)
+	for _, item := range p.list() {
+		p.remove(item)
+	}
+}
+
+// clearOnChange clears the queue whenever settings of prefetchKeys change
+func (p *prefetcher) clearOnChange() {
+	for _, key := range prefetchKeys {
+		config.Observe(key, func(key string, _, _ interface{}) {
+			logrus.WithField("key", key).Info("settings changed, prefetched pictures dropped")
+			p.clear()
+		})
+	}
+}
+
+// pop takes the oldest picture out of the queue. Caller should remove the
+// picture file after use
+func (p *prefetcher) pop() (*prefetchedItem, bool) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	items := p.list()
+	if len(items) == 0 {
+		return nil, false
+	}
+	item := items[0]
+	if err := os.Remove(item.path); err != nil {
+		logrus.WithError(err).Warn("cannot dequeue prefetched picture")
+		return nil, false
+	}
+	return &item, true
+}
+
+// has tells whether a picture of the URL is already queued
+func (p *prefetcher) has(items []prefetchedItem, url string) bool {
+	for _, item := range items {
+		if url != "" && item.Meta != nil && item.Meta.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchOne downloads and renders a picture of the channel into the queue
+func (p *prefetcher) fetchOne(ctx context.Context, name string) bool {
+	l := logrus.WithField("channel", name).WithField("prefetch", true)
+	setting, err := channelSetting(name)
+	if err != nil {
+		l.Error(err)
+		return false
+	}
+	setting.Set("force", force)
+	generation := atomic.LoadInt32(&p.generation)
+
+	raw, img, rendered, meta, err := downloadOneChannel(ctx, name, setting)
+	recordHealth(name, err)
+	if err != nil || img == nil {
+		return false
+	}
+
+	p.l.Lock()
+	defer p.l.Unlock()
+	if atomic.LoadInt32(&p.generation) != generation {
+		l.Debug("settings changed during prefetching, picture dropped")
+		return false
+	}
+	if p.has(p.list(), meta.URL) {
+		l.WithField("url", meta.URL).Debug("picture already prefetched")
+		return false
+	}
+
+	dir := config.GetPrefetchDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		l.WithError(err).Error("cannot create prefetch dir")
+		return false
+	}
+	id := fmt.Sprintf("%d-%s", time.Now().UnixNano(), strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, name))
+	item := prefetchedItem{
+		Meta:      meta,
+		File:      filepath.Join(dir, id+"."+meta.Format),
+		CreatedAt: time.Now(),
+		path:      filepath.Join(dir, id+prefetchItemSuffix),
+	}
+	if err := saveWallpaper(item.File, raw, img, rendered); err != nil {
+		l.WithError(err).Error("cannot save prefetched picture")
+		p.remove(item)
+		return false
+	}
+	bs, err := json.Marshal(item)
+	if err == nil {
+		err = os.WriteFile(item.path, bs, 0644)
+	}
+	if err != nil {
+		l.WithError(err).Error("cannot save prefetched picture")
+		p.remove(item)
+		return false
+	}
+	l.WithField("file", item.File).Info("picture prefetched")
+	return true
+}
+
+// refill downloads pictures from active channels in parallel until the
+// queue is full or all channels are tried. Only one refill runs at a time
+func (p *prefetcher) refill(ctx context.Context) {
+	if !prefetchEnabled() {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&p.filling, 0, 1) {
+		logrus.Debug("prefetching in progress, skip refilling")
+		return
+	}
+	defer atomic.StoreInt32(&p.filling, 0)
+
+	p.l.Lock()
+	missing := int32(util.Settings.GetInt("prefetch.size") - len(p.list()))
+	p.l.Unlock()
+	if missing <= 0 {
+		return
+	}
+	logrus.WithField("missing", missing).Info("prefetching pictures")
+
+	concurrency := util.Settings.GetInt("prefetch.concurrency")
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+
+	// prefetched pictures are used by global cycles, in which channels with
+	// own schedule are not tried
+	channels := excludeScheduled(withDueChannels(ctx, nil), collectActiveChannels())
+	for _, ch := range loadSelector().Select(channels) {
+		if atomic.LoadInt32(&missing) <= 0 || ctx.Err() != nil {
+			break
+		}
+		if isCoolingDown(ch.name) {
+			continue
+		}
+		if dice := rand.Float32(); ch.p < 1 && dice > ch.p {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if atomic.LoadInt32(&missing) > 0 && p.fetchOne(ctx, name) {
+				atomic.AddInt32(&missing, -1)
+			}
+		}(ch.name)
+	}
+	wg.Wait()
+	logrus.WithField("missing", atomic.LoadInt32(&missing)).Debug("prefetching finished")
+}
+
+// popUnbanned dequeues the oldest prefetched picture not banned since it was
+// downloaded, removing banned ones on the way
+func (p *prefetcher) popUnbanned() (*prefetchedItem, bool) {
+	for {
+		item, ok := p.pop()
+		if !ok || item.Meta == nil || item.Meta.URL == "" || !history.JSONHistoryManagerSingleton.IsBanned(item.Meta.URL) {
+			return item, ok
+		}
+		logrus.WithField("url", item.Meta.URL).Info("prefetched picture banned, discarded")
+		_ = os.Remove(item.File)
+	}
+}
+
+// applyPrefetched sets the oldest prefetched picture as wallpaper. It
+// returns nil metadata if no picture is ready
+func applyPrefetched(setter setter.Setter) (*channel.PictureMeta, error) {
+	item, ok := defaultPrefetcher.popUnbanned()
+	if !ok {
+		logrus.Debug("no prefetched picture ready")
+		return nil, nil
+	}
+	defer func() {
+		_ = os.Remove(item.File)
+	}()
+	l := logrus.WithField("channel", item.Meta.ChannelKey).WithField("file", item.File)
+
+	wallpaperFileName := config.GetWallpaperFileName() + filepath.Ext(item.File)
+	if _, err := util.CopyFile(item.File, wallpaperFileName); err != nil {
+		l.WithError(err).Error("cannot use prefetched picture")
+		return nil, err
+	}
+
+	l.Info("setting prefetched wallpaper...")
+	if err := setter.Set(wallpaperFileName); err != nil {
+		l.Error(err)
+		return nil, err
+	}
+	return item.Meta, nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/history"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var probeCmd = &cobra.Command{
+	Use:   "probe <channel-key>",
+	Short: "Show what a channel would download without setting the wallpaper",
+	Long: `Run a channel defined in the configuration file and print the resolved picture URL,
+metadata and dimensions. The history file is never updated and the desktop is left untouched.
+Use --save to write the cropped and watermarked picture to a file.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := probe(ctx, args[0]); err != nil {
+			logrus.WithError(err).Errorln("probe failed")
+			os.Exit(1)
+		}
+	},
+}
+
+var probeFormat string
+var probeSavePath string
+
+func init() {
+	probeCmd.Flags().StringVarP(&probeFormat, "format", "f", "text", "output format, text or json")
+	probeCmd.Flags().StringVarP(&probeSavePath, "save", "s", "", "save the rendered picture to the path")
+	RootCmd.AddCommand(probeCmd)
+}
+
+type probeResult struct {
+	ChannelKey     string
+	Meta           *channel.PictureMeta
+	Width          int
+	Height         int
+	RenderedWidth  int
+	RenderedHeight int
+	SavedTo        string `json:",omitempty"`
+}
+
+func probe(ctx context.Context, name string) error {
+	if probeFormat != "text" && probeFormat != "json" {
+		return fmt.Errorf("unknown output format %s", probeFormat)
+	}
+
+	setting, err := channelSetting(name)
+	if err != nil {
+		return err
+	}
+	history.JSONHistoryManagerSingleton.SetReadOnly(true)
+	// always download since history cannot be updated anyway
+	setting.Set("force", true)
+
+	raw, img, rendered, meta, err := downloadOneChannel(ctx, name, setting)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return errNoAvailableChannel
+	}
+
+	result := probeResult{ChannelKey: name, Meta: meta}
+	if img != nil {
+		result.Width, result.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		result.RenderedWidth, result.RenderedHeight = rendered.Bounds().Dx(), rendered.Bounds().Dy()
+		if probeSavePath != "" {
+			result.SavedTo = config.MustExpand(probeSavePath)
+			if err := saveWallpaper(result.SavedTo, raw, img, rendered); err != nil {
+				return err
+			}
+		}
+	}
+
+	if probeFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	fmt.Printf("channel:       %s (%s)\n", result.ChannelKey, meta.Channel)
+	fmt.Printf("url:           %s\n", meta.URL)
+	fmt.Printf("title:         %s\n", meta.Title)
+	fmt.Printf("caption:       %s\n", meta.Caption)
+	fmt.Printf("credit:        %s\n", meta.Credit)
+	fmt.Printf("format:        %s\n", meta.Format)
+	fmt.Printf("upload time:   %s\n", meta.UploadTime.Local())
+	fmt.Printf("download time: %s\n", meta.DownloadTime.Local())
+	if img == nil {
+		fmt.Println("picture:       not downloaded")
+		return nil
+	}
+	fmt.Printf("size:          %dx%d\n", result.Width, result.Height)
+	fmt.Printf("rendered size: %dx%d\n", result.RenderedWidth, result.RenderedHeight)
+	if result.SavedTo != "" {
+		fmt.Printf("saved to:      %s\n", result.SavedTo)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// profileAuto chooses the profile by profile-rules or the profile key again
+const profileAuto = "auto"
+
+var profileName string
+
+var profileCmd = &cobra.Command{
+	Use:   "profile [name|auto]",
+	Short: "Show or switch the profile of the running daemon",
+	Long: `Show profiles defined in the config file, or switch the running daemon to the
+named profile. With "auto" the daemon chooses the profile by profile-rules,
+or uses the profile key of the config file if no rule matches.
+
+Use --profile to choose the profile of other commands.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			listProfiles()
+			return
+		}
+		name := args[0]
+		if name == profileAuto {
+			name = ""
+		}
+		if !forwardToDaemon(controlRequest{Command: controlProfile, Profile: name}) {
+			logrus.WithError(errNoDaemon).Errorln("cannot switch profile")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "profile overriding active channels, watermark and crop settings")
+	RootCmd.AddCommand(profileCmd)
+}
+
+// listProfiles prints defined profiles, with the one in effect marked
+func listProfiles() {
+	active := config.ActiveProfile()
+	if resp, err := sendControl(controlRequest{Command: controlStatus}); err == nil {
+		active = resp.Status.Profile
+	}
+	profiles := config.Profiles()
+	if len(profiles) == 0 {
+		fmt.Println("no profile defined")
+		return
+	}
+	for _, name := range profiles {
+		if name == active {
+			fmt.Printf("* %s\n", name)
+		} else {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+}
+
+// initProfile selects the profile given by --profile, or the one chosen by
+// profile-rules now
+func initProfile() {
+	if profileName != "" {
+		if err := config.SelectProfile(profileName); err != nil {
+			logrus.WithError(err).Fatal("cannot select profile")
+		}
+		return
+	}
+	updateScheduledProfile(time.Now())
+}
+
+// profileRule chooses the profile at matched hours and weekdays
+type profileRule struct {
+	timeOfDayRule `mapstructure:",squash"`
+	Profile       string `mapstructure:"profile"`
+}
+
+// scheduledProfile returns the profile of the first rule matching now, empty
+// if none matches
+func scheduledProfile(now time.Time) string {
+	var rules []profileRule
+	if err := util.MapToStruct(util.Settings.Get("profile-rules"), &rules); err != nil {
+		logrus.WithError(err).Warn("cannot parse profile rules")
+		return ""
+	}
+	for idx, rule := range rules {
+		if rule.matches(now) {
+			logrus.WithField("rule", idx).WithField("profile", rule.Profile).Debug("profile rule matched")
+			return rule.Profile
+		}
+	}
+	return ""
+}
+
+func updateScheduledProfile(now time.Time) {
+	if err := config.ScheduleProfile(scheduledProfile(now)); err != nil {
+		logrus.WithError(err).Warn("cannot switch to the scheduled profile")
+	}
+}
+
+// scheduleProfiles checks profile rules at the start of every minute until
+// ctx is done
+func scheduleProfiles(ctx context.Context) {
+	config.Observe(config.ProfileKey, func(key string, old, new interface{}) {
+		logrus.WithField("from", old).WithField("to", new).Info("profile switched")
+	})
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now = <-timer.C:
+			updateScheduledProfile(now)
+		}
+	}
+}
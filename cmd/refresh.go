@@ -1,18 +1,26 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/genzj/goTApaper/actor"
 	"github.com/genzj/goTApaper/actor/setter"
-	"github.com/genzj/goTApaper/actor/watermark"
+	// registers the watermark actor run by actor.RunPipeline
+	_ "github.com/genzj/goTApaper/actor/watermark"
 	"github.com/genzj/goTApaper/channel"
 	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/history"
+	"github.com/genzj/goTApaper/util"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"image"
 	"image/jpeg"
 	"math/rand"
 	"os"
+	"os/signal"
+	"time"
 )
 
 var (
@@ -22,9 +30,23 @@ var (
 var refreshCmd = &cobra.Command{
 	Use:   "refresh",
 	Short: "Trigger pic downloading and wallpaper setting",
-	Long:  `Trigger pic downloading and wallpaper setting`,
+	Long: `Trigger pic downloading and wallpaper setting. If a daemon is running, it
+is asked to refresh instead.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		refresh(args)
+		req := controlRequest{Command: controlRefresh, Channels: args, Force: force}
+		if cmd.Flags().Changed("setter") {
+			req.Setter = util.Settings.GetString("setter")
+		}
+		if cmd.Flags().Changed("timeout") {
+			timeout := util.Settings.GetUint32("refresh-timeout")
+			req.Timeout = &timeout
+		}
+		if forwardToDaemon(req) {
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		refresh(ctx, args)
 	},
 }
 
@@ -34,6 +56,8 @@ func init() {
 	refreshCmd.PersistentFlags().String("setter", config.DefaultSetter, "setter to configure desktop wallpaper")
 	viper.BindPFlag("setter", refreshCmd.PersistentFlags().Lookup("setter"))
 	refreshCmd.PersistentFlags().BoolVar(&force, "force", false, "ignore history file and always download")
+	refreshCmd.PersistentFlags().Uint32("timeout", config.DefaultRefreshTimeout, "seconds allowed for the whole refresh")
+	viper.BindPFlag("refresh-timeout", refreshCmd.PersistentFlags().Lookup("timeout"))
 	RootCmd.AddCommand(refreshCmd)
 }
 
@@ -49,16 +73,16 @@ func addNewChannel(channels []channelsWithProbability, name string, p float32) [
 	})
 }
 
-func collectActiveChannels() []channelsWithProbability {
+// parseChannelList reads a list of channel names or name-probability maps
+func parseChannelList(key string, value interface{}) []channelsWithProbability {
 	var ans []channelsWithProbability
 
-	activeChannels := viper.Get("active-channels")
-	logrus.Debugf("active channels: %#v %T", activeChannels, activeChannels)
-	if channels, ok := activeChannels.([]string); ok {
+	logrus.Debugf("%s: %#v %T", key, value, value)
+	if channels, ok := value.([]string); ok {
 		for _, ch := range channels {
 			ans = addNewChannel(ans, ch, 1.0)
 		}
-	} else if channels, ok := activeChannels.([]interface{}); ok {
+	} else if channels, ok := value.([]interface{}); ok {
 	channelsLoop:
 		for _, value := range channels {
 			switch ch := value.(type) {
@@ -76,6 +100,8 @@ func collectActiveChannels() []channelsWithProbability {
 						ans = addNewChannel(ans, ks, vf)
 					} else if vf, ok := v.(float64); ok {
 						ans = addNewChannel(ans, ks, float32(vf))
+					} else if vi, ok := v.(int); ok {
+						ans = addNewChannel(ans, ks, float32(vi))
 					} else {
 						logrus.Warnf("invalid channel definition: non-float key %T %#v", v, v)
 						continue channelsLoop
@@ -86,11 +112,13 @@ func collectActiveChannels() []channelsWithProbability {
 			}
 		}
 	} else {
-		logrus.Errorf("active-channels should be defined as a list")
+		logrus.Errorf("%s should be defined as a list", key)
 	}
+	return ans
+}
 
-	// probability of the last item is always one to guarantee at least one detection
-	ans[len(ans)-1].p = 1.0
+func collectActiveChannels() []channelsWithProbability {
+	ans := parseChannelList("active-channels", util.Settings.Get("active-channels"))
 	if len(ans) == 0 {
 		logrus.Warnf("no channels found in the configuration file %s", viper.ConfigFileUsed())
 	} else {
@@ -111,55 +139,86 @@ func collectSpecifiedChannels(specifiedChannels []string) []channelsWithProbabil
 	return ans
 }
 
-func refresh(specifiedChannels []string) (*channel.PictureMeta, error) {
-	// reread config, in case refresh is called by daemon after a long sleep
-	// during which user updated the config file
-	if viper.ConfigFileUsed() == "" {
-		logrus.Debugln("Using default config file")
-	} else if err := viper.ReadInConfig(); err == nil {
-		logrus.Debugln("Using config file:", viper.ConfigFileUsed())
-	} else {
-		logrus.WithFields(logrus.Fields{
-			"CfgFile": viper.ConfigFileUsed(),
-		}).Fatalf("Config File is not readable: %s", err)
+// channelTimeout reads the download timeout of a channel, fallback to the
+// global channel-timeout if the channel doesn't specify one
+func channelTimeout(setting *viper.Viper) time.Duration {
+	if setting.IsSet("timeout") {
+		return time.Duration(setting.GetInt("timeout")) * time.Second
 	}
+	return time.Duration(util.Settings.GetInt("channel-timeout")) * time.Second
+}
 
+func refresh(ctx context.Context, specifiedChannels []string) (*channel.PictureMeta, error) {
 	activeChannels := collectSpecifiedChannels(specifiedChannels)
+	// channels specified explicitly are always tried, even if cooling down
+	skipUnhealthy := len(activeChannels) == 0
 
+	selector := loadSelector()
 	if len(activeChannels) == 0 {
-		activeChannels = collectActiveChannels()
+		activeChannels = selector.Select(excludeScheduled(ctx, collectActiveChannels()))
+		logrus.Debugf("channels selected: %#v", activeChannels)
 	}
 
-	setterName := viper.GetString("setter")
+	setterName := util.Settings.GetString("setter")
+	if name, ok := requestedSetter(ctx); ok {
+		setterName = name
+	}
 	v, ok := setter.Setters.Get(setterName)
 	if !ok {
 		logrus.Panicf("setter \"%s\" not registered", setterName)
 	}
 	setter := v.(setter.Setter)
 
+	timeout := time.Duration(util.Settings.GetInt("refresh-timeout")) * time.Second
+	if seconds, ok := requestedTimeout(ctx); ok {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if skipUnhealthy && prefetchEnabled() {
+		meta, err := applyPrefetched(setter)
+		if err == nil && meta != nil {
+			selector.Selected(meta.ChannelKey)
+			recordWallpaper(meta)
+			return meta, nil
+		}
+	}
+
 	for _, ch := range activeChannels {
 		name, probability := ch.name, ch.p
 		l := logrus.WithField("channel", name)
+		if err := ctx.Err(); err != nil {
+			l.WithError(err).Warn("refresh aborted before trying channel")
+			return nil, err
+		}
 		dice := rand.Float32()
 		if probability < 1 && dice > probability {
 			l.WithField("dice", dice).WithField("probability", probability).Info("skipped randomly")
 			continue
 		}
-		setting := viper.Sub("channels." + name)
-		if setting == nil {
-			l.Error("cannot find channel definition")
+		if skipUnhealthy && isCoolingDown(name) {
 			continue
-		} else if !setting.IsSet("type") {
-			l.Error("type of channel not set")
+		}
+		setting, err := channelSetting(name)
+		if err != nil {
+			l.Error(err)
 			continue
 		}
 
-		setting.Set("force", force)
-		l.Debugf("setting: %#v", setting.AllSettings())
+		setting.Set("force", force || forced(ctx))
+		l.Debugf("setting: %#v", util.MaskSecrets(setting.AllSettings()))
 
-		if meta, err := detectOneChannel(name, setting, setter); err != nil || meta == nil {
+		meta, err := detectOneChannel(ctx, name, setting, setter)
+		recordHealth(name, err)
+		if err != nil || meta == nil {
 			continue
 		} else {
+			selector.Selected(name)
+			recordWallpaper(meta)
 			// exit on first success. following channels will be detected on next schedule with help of the history mechanism
 			return meta, err
 		}
@@ -168,14 +227,55 @@ func refresh(specifiedChannels []string) (*channel.PictureMeta, error) {
 	return nil, errNoAvailableChannel
 }
 
-func detectOneChannel(name string, setting *viper.Viper, setter setter.Setter) (*channel.PictureMeta, error) {
+// channelSetting returns the definition of the channel, with secret
+// references resolved and global settings of cropping and rendering merged.
+// The global settings keep the references, so that secrets are never saved
+// into the config file
+func channelSetting(name string) (*viper.Viper, error) {
+	sub := util.Settings.Sub("channels." + name)
+	if sub == nil {
+		return nil, fmt.Errorf("cannot find channel definition")
+	} else if !sub.IsSet("type") {
+		return nil, fmt.Errorf("type of channel not set")
+	}
+	resolved, err := util.ResolveSecrets(sub.AllSettings())
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve secrets of channel %s: %w", name, err)
+	}
+	setting := viper.New()
+	if err := setting.MergeConfigMap(resolved.(map[string]interface{})); err != nil {
+		return nil, err
+	}
+	if err := actor.MergeRenderSetting(setting); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+// downloadOneChannel downloads a picture from the channel, then processes it
+// by the actor pipeline. rendered is the same as img if nothing changed
+func downloadOneChannel(ctx context.Context, name string, setting *viper.Viper) (raw *bytes.Reader, img, rendered image.Image, meta *channel.PictureMeta, err error) {
 	l := logrus.WithField("channel", name)
-	wallpaperPath := config.GetWallpaperFileName()
 
-	raw, img, meta, err := channel.Channels.Run(setting.GetString("type"), setting)
+	if timeout := channelTimeout(setting); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ctx = util.WithRetryPolicy(ctx, util.LoadRetryPolicy(setting))
+
+	client, err := util.NewClient(util.LoadHTTPSettings(setting))
+	if err != nil {
+		l.WithError(err).Error("cannot initiate http client of channel")
+		return nil, nil, nil, nil, err
+	}
+	ctx = util.WithClient(ctx, client)
+
+	raw, img, meta, err = channel.Channels.Run(ctx, setting.GetString("type"), setting)
 	if err != nil {
 		l.Error(err)
-		return nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	if meta != nil {
@@ -184,24 +284,42 @@ func detectOneChannel(name string, setting *viper.Viper, setter setter.Setter) (
 		l.Debugf("picture metadata %##v", meta)
 	} else {
 		l.Warn("no picture metadata")
-		return nil, err
+		return nil, nil, nil, nil, err
+	}
+
+	if meta.URL != "" && history.JSONHistoryManagerSingleton.IsBanned(meta.URL) {
+		err = fmt.Errorf("%w: banned", actor.ErrRejected)
+		l.WithError(err).WithField("url", meta.URL).Warn("picture rejected")
+		return nil, nil, nil, nil, err
 	}
 
 	if raw == nil || img == nil {
 		l.Infoln("no image downloaded")
-		return nil, err
+		return nil, nil, nil, meta, err
 	}
 
-	newImg := actor.DefaultCropper.Crop(img)
+	filter, err := actor.LoadFilterSetting(setting)
+	if err != nil {
+		l.WithError(err).Warn("cannot parse filters, skip filtering")
+	} else if err = filter.Check(img, meta); err != nil {
+		l.WithError(err).WithField("url", meta.URL).Warn("picture rejected")
+		return nil, nil, nil, nil, err
+	}
 
-	newImg, _ = watermark.Render(newImg, meta)
+	rendered, err = actor.RunPipeline(actor.WithSetting(ctx, setting), img, meta)
+	if err != nil {
+		l.WithError(err).Error("cannot process picture")
+		return nil, nil, nil, nil, err
+	}
 
-	wallpaperFileName := wallpaperPath + "." + meta.Format
+	return raw, img, rendered, meta, nil
+}
 
-	out, err := os.Create(wallpaperFileName)
+// saveWallpaper writes the picture into the file
+func saveWallpaper(fileName string, raw *bytes.Reader, img, rendered image.Image) error {
+	out, err := os.Create(fileName)
 	if err != nil {
-		l.Error(err)
-		return nil, err
+		return err
 	}
 	defer func(out *os.File) {
 		err := out.Close()
@@ -210,19 +328,31 @@ func detectOneChannel(name string, setting *viper.Viper, setter setter.Setter) (
 		}
 	}(out)
 
-	if newImg != img {
+	if rendered != img {
 		// cropping or rendering changed the photo, save it as jpeg
-		img = newImg
-		err = jpeg.Encode(
-			out, img, &jpeg.Options{
+		return jpeg.Encode(
+			out, rendered, &jpeg.Options{
 				Quality: 90,
 			},
 		)
-	} else {
-		// use raw bytes to avoid picture quality loss
-		_, err = raw.WriteTo(out)
 	}
-	if err != nil {
+	// use raw bytes to avoid picture quality loss
+	_, err = raw.WriteTo(out)
+	return err
+}
+
+func detectOneChannel(ctx context.Context, name string, setting *viper.Viper, setter setter.Setter) (*channel.PictureMeta, error) {
+	l := logrus.WithField("channel", name)
+	wallpaperPath := config.GetWallpaperFileName()
+
+	raw, img, rendered, meta, err := downloadOneChannel(ctx, name, setting)
+	if err != nil || img == nil {
+		return nil, err
+	}
+
+	wallpaperFileName := wallpaperPath + "." + meta.Format
+
+	if err = saveWallpaper(wallpaperFileName, raw, img, rendered); err != nil {
 		l.Error(err)
 		return nil, err
 	}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/genzj/goTApaper/config"
+	"github.com/genzj/goTApaper/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -14,6 +15,7 @@ const AppName string = "goTApaper"
 
 var cfgFile string
 var lang string
+var recordDir, replayDir string
 
 // RootCmd is the entry of whole program
 var RootCmd = &cobra.Command{
@@ -35,6 +37,32 @@ func initConfig() {
 	config.SetAppName(AppName)
 	config.EnsureAppDir()
 	config.LoadConfig(cfgFile)
+	initProfile()
+	initReplayMode()
+	initHTTPCache()
+}
+
+func initReplayMode() {
+	if recordDir != "" {
+		util.SetReplayMode(util.ReplayModeRecord, config.MustExpand(recordDir))
+	} else if replayDir != "" {
+		util.SetReplayMode(util.ReplayModeReplay, config.MustExpand(replayDir))
+	}
+}
+
+func initHTTPCache() {
+	if !util.Settings.GetBool("http-cache.enabled") || recordDir != "" || replayDir != "" {
+		// recordings must hold complete responses instead of cache hits and
+		// revalidations, and replayed responses must not be shadowed by
+		// cached ones
+		util.SetHTTPCache(nil)
+		return
+	}
+	util.SetHTTPCache(util.NewHTTPCache(
+		config.GetHTTPCacheDir(),
+		util.Settings.GetInt64("http-cache.max-size"),
+		util.Settings.GetBool("http-cache.offline-fallback"),
+	))
 }
 
 func init() {
@@ -50,4 +78,7 @@ func init() {
 	viper.BindPFlag("debug", RootCmd.PersistentFlags().Lookup("debug"))
 	RootCmd.PersistentFlags().Bool("debug-rendering", false, "enable debug mode in watermark rendering")
 	viper.BindPFlag("debug-rendering", RootCmd.PersistentFlags().Lookup("debug-rendering"))
+	RootCmd.PersistentFlags().StringVar(&recordDir, "record", "", "save all http requests and responses into the folder, for offline testing or bug reports")
+	RootCmd.PersistentFlags().StringVar(&replayDir, "replay", "", "serve http requests from responses saved by --record in the folder, without network access")
+	RootCmd.MarkFlagsMutuallyExclusive("record", "replay")
 }
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+)
+
+// cycle describes a scheduled refresh of the daemon
+type cycle struct {
+	at time.Time
+	// global tells whether the cycle is due to daemon.schedule or
+	// daemon.interval, in which all channels without own schedule are tried
+	global bool
+	// channels with own schedule due at the time
+	channels []string
+}
+
+type scheduledChannelsKey struct{}
+
+// withDueChannels marks a refresh as a global cycle in which only the given
+// channels among those with own schedule are tried
+func withDueChannels(ctx context.Context, channels []string) context.Context {
+	return context.WithValue(ctx, scheduledChannelsKey{}, channels)
+}
+
+// excludeScheduled drops channels with own schedule not due in a global
+// cycle, so that they are only tried on their own schedule
+func excludeScheduled(ctx context.Context, channels []channelsWithProbability) []channelsWithProbability {
+	due, ok := ctx.Value(scheduledChannelsKey{}).([]string)
+	if !ok {
+		return channels
+	}
+	var ans []channelsWithProbability
+	for _, ch := range channels {
+		if !util.Settings.IsSet("channels."+ch.name+".schedule") || containsString(due, ch.name) {
+			ans = append(ans, ch)
+		} else {
+			logrus.WithField("channel", ch.name).Debug("skipped in favour of its own schedule")
+		}
+	}
+	return ans
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleExpressions accepts a single cron expression or a list of them
+func scheduleExpressions(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		ans := make([]string, 0, len(v))
+		for _, item := range v {
+			ans = append(ans, fmt.Sprint(item))
+		}
+		return ans
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// parseSchedule returns valid cron schedules of the key, invalid expressions
+// are logged and ignored
+func parseSchedule(key string) []*util.CronSchedule {
+	var ans []*util.CronSchedule
+	for _, expr := range scheduleExpressions(util.Settings.Get(key)) {
+		s, err := util.ParseCron(expr)
+		if err != nil {
+			logrus.WithError(err).WithField("key", key).Warn("invalid schedule ignored")
+			continue
+		}
+		ans = append(ans, s)
+	}
+	return ans
+}
+
+func nextOf(schedules []*util.CronSchedule, now time.Time) time.Time {
+	var next time.Time
+	for _, s := range schedules {
+		if t := s.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// parseClock parses "15:04" or "15:04:05" into the offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+				time.Duration(t.Second())*time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time of day %q", value)
+}
+
+// nextInterval returns the next global cycle by daemon.interval. With
+// daemon.align-to, cycles happen at the given time of day plus multiples
+// of the interval instead of counting from now
+func nextInterval(now time.Time) time.Time {
+	interval := time.Duration(util.Settings.GetInt("daemon.interval")) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	alignTo := util.Settings.GetString("daemon.align-to")
+	if alignTo == "" {
+		return now.Add(interval)
+	}
+	offset, err := parseClock(alignTo)
+	if err != nil {
+		logrus.WithError(err).Warn("daemon.align-to ignored")
+		return now.Add(interval)
+	}
+	y, m, d := now.Date()
+	base := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(offset)
+	elapsed := now.Sub(base)
+	steps := elapsed / interval
+	if elapsed%interval < 0 {
+		steps--
+	}
+	return base.Add((steps + 1) * interval)
+}
+
+// nextCycleAt computes when the daemon should refresh next and for which
+// channels
+func nextCycleAt(now time.Time) cycle {
+	var global time.Time
+	if schedules := parseSchedule("daemon.schedule"); len(schedules) > 0 {
+		global = nextOf(schedules, now)
+	} else {
+		global = nextInterval(now)
+	}
+
+	next := cycle{at: global, global: !global.IsZero()}
+	names := make([]string, 0)
+	for _, ch := range parseChannelList("active-channels", util.Settings.Get("active-channels")) {
+		names = append(names, ch.name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := nextOf(parseSchedule("channels."+name+".schedule"), now)
+		switch {
+		case t.IsZero():
+		case next.at.IsZero() || t.Before(next.at):
+			next = cycle{at: t, channels: []string{name}}
+		case t.Equal(next.at):
+			next.channels = append(next.channels, name)
+		}
+	}
+	if next.at.IsZero() {
+		// nothing scheduled, check again later
+		next = cycle{at: now.Add(24 * time.Hour)}
+	}
+	return next
+}
+
+const (
+	// clockCheckInterval is the longest sleep of the daemon, after which the
+	// wall clock is checked for jumps caused by suspend or time adjustment
+	clockCheckInterval = 30 * time.Second
+	// clockJumpTolerance is the largest difference between wall clock and
+	// monotonic clock considered normal in a sleep
+	clockJumpTolerance = 5 * time.Second
+)
+
+// sleepUntilDue waits until the cycle is due by the wall clock, so that
+// cycles missed during suspend are due right after resume. A backward clock
+// jump reschedules the cycle. It returns early with triggered set if a
+// refresh is requested before the cycle
+func sleepUntilDue(ctx context.Context, next cycle, trigger nextCycleWaitChannel) (due cycle, req cycleRequest, triggered bool, err error) {
+	last := time.Now()
+	for {
+		wall := last.Round(0)
+		if !wall.Before(next.at) {
+			return next, req, false, nil
+		}
+		wait := next.at.Sub(wall)
+		if wait > clockCheckInterval {
+			wait = clockCheckInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return next, req, false, ctx.Err()
+		case req = <-trigger:
+			timer.Stop()
+			return next, req, true, nil
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		// wall clock keeps going during suspend while monotonic clock stops
+		if drift := now.Round(0).Sub(wall) - now.Sub(last); drift > clockJumpTolerance {
+			logrus.WithField("drift", drift).Info("wall clock jumped forward, maybe resumed from suspend")
+		} else if drift < -clockJumpTolerance {
+			logrus.WithField("drift", drift).Info("wall clock jumped backward, rescheduling")
+			next = nextCycleAt(now.Round(0))
+			currentDaemon.setNextCycle(next.at)
+		}
+		last = now
+	}
+}
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/genzj/goTApaper/history"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	selectionOrdered           = "ordered"
+	selectionWeightedRandom    = "weighted-random"
+	selectionRoundRobin        = "round-robin"
+	selectionLeastRecentlyUsed = "least-recently-used"
+	selectionTimeOfDay         = "time-of-day"
+
+	// history meta keys keeping channel usage across restarts
+	lastUsedMetaPrefix   = "last-used."
+	roundRobinMetaKey    = "round-robin.last"
+	fallbackSelectorName = selectionOrdered
+)
+
+// Selector decides which active channels to try, and in which order, in a
+// refresh
+type Selector interface {
+	// Select returns channels to be tried in order. A channel is skipped if
+	// the dice is greater than its probability
+	Select(candidates []channelsWithProbability) []channelsWithProbability
+	// Selected is called once a channel sets the wallpaper
+	Selected(name string)
+}
+
+// Selectors keeps all registered channel selection policies
+var Selectors = util.RegistryMap{}
+
+func loadSelector() Selector {
+	name := util.Settings.GetString("channel-selection.policy")
+	if v, ok := Selectors.Get(name); ok {
+		return v.(Selector)
+	}
+	logrus.Warnf("unknown channel selection policy %s, fallback to %s", name, fallbackSelectorName)
+	v, _ := Selectors.Get(fallbackSelectorName)
+	return v.(Selector)
+}
+
+// usageTracker remembers the last used time of channels and the last
+// channel used, so that policies can be switched without losing state
+type usageTracker struct{}
+
+func (usageTracker) Selected(name string) {
+	m := history.JSONHistoryManagerSingleton
+	if err := m.SaveMeta(lastUsedMetaPrefix+name, time.Now().Format(time.RFC3339)); err != nil {
+		logrus.WithError(err).Warn("cannot save channel usage")
+	}
+	if err := m.SaveMeta(roundRobinMetaKey, name); err != nil {
+		logrus.WithError(err).Warn("cannot save channel usage")
+	}
+}
+
+func lastUsed(name string) time.Time {
+	value, err := history.JSONHistoryManagerSingleton.LoadMeta(lastUsedMetaPrefix + name)
+	if err != nil || value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		logrus.WithError(err).Warnf("invalid last used time of %s", name)
+	}
+	return t
+}
+
+// alwaysTry drops probabilities of channels
+func alwaysTry(channels []channelsWithProbability) []channelsWithProbability {
+	ans := make([]channelsWithProbability, 0, len(channels))
+	for _, ch := range channels {
+		ans = addNewChannel(ans, ch.name, 1.0)
+	}
+	return ans
+}
+
+// orderedSelector tries channels in the configured order, each subjected to
+// its probability
+type orderedSelector struct {
+	usageTracker
+}
+
+func (orderedSelector) Select(candidates []channelsWithProbability) []channelsWithProbability {
+	if len(candidates) == 0 {
+		return candidates
+	}
+	ans := append([]channelsWithProbability{}, candidates...)
+	// probability of the last item is always one to guarantee at least one detection
+	ans[len(ans)-1].p = 1.0
+	return ans
+}
+
+// weightedRandomSelector uses probabilities as weights to pick the first
+// channel, then the next one from the rest and so on
+type weightedRandomSelector struct {
+	usageTracker
+}
+
+func (weightedRandomSelector) Select(candidates []channelsWithProbability) []channelsWithProbability {
+	var pool []channelsWithProbability
+	for _, ch := range candidates {
+		if ch.p > 0 {
+			pool = append(pool, ch)
+		}
+	}
+	if len(pool) == 0 {
+		pool = append(pool, candidates...)
+		for i := range pool {
+			pool[i].p = 1.0
+		}
+	}
+
+	var ans []channelsWithProbability
+	for len(pool) > 0 {
+		var total float32
+		for _, ch := range pool {
+			total += ch.p
+		}
+		dice := rand.Float32() * total
+		idx := len(pool) - 1
+		for i, ch := range pool {
+			if dice < ch.p {
+				idx = i
+				break
+			}
+			dice -= ch.p
+		}
+		ans = addNewChannel(ans, pool[idx].name, 1.0)
+		pool = append(pool[:idx], pool[idx+1:]...)
+	}
+	return ans
+}
+
+// roundRobinSelector starts from the channel next to the last used one
+type roundRobinSelector struct {
+	usageTracker
+}
+
+func (roundRobinSelector) Select(candidates []channelsWithProbability) []channelsWithProbability {
+	ans := alwaysTry(candidates)
+	last, err := history.JSONHistoryManagerSingleton.LoadMeta(roundRobinMetaKey)
+	if err != nil || last == "" {
+		return ans
+	}
+	for i, ch := range ans {
+		if ch.name == last {
+			return append(ans[i+1:], ans[:i+1]...)
+		}
+	}
+	return ans
+}
+
+// leastRecentlyUsedSelector starts from the channel not used for the
+// longest time
+type leastRecentlyUsedSelector struct {
+	usageTracker
+}
+
+func (leastRecentlyUsedSelector) Select(candidates []channelsWithProbability) []channelsWithProbability {
+	ans := alwaysTry(candidates)
+	used := make(map[string]time.Time, len(ans))
+	for _, ch := range ans {
+		used[ch.name] = lastUsed(ch.name)
+	}
+	sort.SliceStable(ans, func(i, j int) bool {
+		return used[ans[i].name].Before(used[ans[j].name])
+	})
+	return ans
+}
+
+type timeOfDayRule struct {
+	// Hours is a range like "8-18" (from 8:00 to 17:59) or "22-6" across
+	// midnight. Empty means all day
+	Hours string `mapstructure:"hours"`
+	// Weekdays like "mon", "tue". Empty means every day
+	Weekdays []string `mapstructure:"weekdays"`
+	// Channels in the same format as active-channels
+	Channels interface{} `mapstructure:"channels"`
+	// Policy used to order the channels of the rule, default to ordered
+	Policy string `mapstructure:"policy"`
+}
+
+func parseHourRange(hours string) (start, end int, err error) {
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid hour range %s", hours)
+	}
+	if start, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, err
+	}
+	if end, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+		return 0, 0, err
+	}
+	if start < 0 || start > 24 || end < 0 || end > 24 {
+		return 0, 0, fmt.Errorf("hour out of range in %s", hours)
+	}
+	return start, end, nil
+}
+
+func (r timeOfDayRule) matches(now time.Time) bool {
+	if r.Hours != "" {
+		start, end, err := parseHourRange(r.Hours)
+		if err != nil {
+			logrus.WithError(err).Warn("time-of-day rule ignored")
+			return false
+		}
+		h := now.Hour()
+		if start <= end && (h < start || h >= end) {
+			return false
+		} else if start > end && h < start && h >= end {
+			return false
+		}
+	}
+	if len(r.Weekdays) == 0 {
+		return true
+	}
+	today := strings.ToLower(now.Weekday().String()[:3])
+	for _, day := range r.Weekdays {
+		if d := strings.ToLower(strings.TrimSpace(day)); len(d) >= 3 && d[:3] == today {
+			return true
+		}
+	}
+	return false
+}
+
+// timeOfDaySelector picks the channel list of the first rule matching the
+// current hour and weekday, fallback to active-channels if none matches
+type timeOfDaySelector struct {
+	usageTracker
+}
+
+func (timeOfDaySelector) Select(candidates []channelsWithProbability) []channelsWithProbability {
+	var rules []timeOfDayRule
+	if err := util.MapToStruct(util.Settings.Get("channel-selection.rules"), &rules); err != nil {
+		logrus.WithError(err).Warn("cannot parse time-of-day rules")
+	}
+
+	now := time.Now()
+	for idx, rule := range rules {
+		if !rule.matches(now) {
+			continue
+		}
+		channels := parseChannelList(fmt.Sprintf("channel-selection.rules[%d].channels", idx), rule.Channels)
+		logrus.WithField("rule", idx).Debugf("time-of-day rule matched: %#v", channels)
+		return ruleSelector(rule.Policy).Select(channels)
+	}
+	logrus.Debug("no time-of-day rule matched, use active-channels")
+	return orderedSelector{}.Select(candidates)
+}
+
+func ruleSelector(name string) Selector {
+	if name == "" || name == selectionTimeOfDay {
+		name = selectionOrdered
+	}
+	if v, ok := Selectors.Get(name); ok {
+		return v.(Selector)
+	}
+	logrus.Warnf("unknown channel selection policy %s in time-of-day rule, fallback to %s", name, fallbackSelectorName)
+	return orderedSelector{}
+}
+
+func init() {
+	Selectors.Register(selectionOrdered, orderedSelector{})
+	Selectors.Register(selectionWeightedRandom, weightedRandomSelector{})
+	Selectors.Register(selectionRoundRobin, roundRobinSelector{})
+	Selectors.Register(selectionLeastRecentlyUsed, leastRecentlyUsedSelector{})
+	Selectors.Register(selectionTimeOfDay, timeOfDaySelector{})
+}
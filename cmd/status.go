@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/genzj/goTApaper/history"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show state of the daemon and health of channels",
+	Long: `Show state of the running daemon, and recent failures, last error and
+cool-down state of channels`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := status(); err != nil {
+			logrus.WithError(err).Errorln("cannot show status")
+			os.Exit(1)
+		}
+	},
+}
+
+var statusFormat string
+
+func init() {
+	statusCmd.Flags().StringVarP(&statusFormat, "format", "f", "text", "output format, text or json")
+	RootCmd.AddCommand(statusCmd)
+}
+
+// sortedHealth returns health of all channels ordered by name
+func sortedHealth() ([]history.Health, error) {
+	all, err := history.JSONHealthManagerSingleton.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+	ans := make([]history.Health, 0, len(all))
+	for _, h := range all {
+		ans = append(ans, h)
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].Name < ans[j].Name
+	})
+	return ans, nil
+}
+
+// describeHealth summarizes health of a channel in one line
+func describeHealth(h history.Health, now time.Time) string {
+	switch {
+	case h.CoolingDown(now):
+		return fmt.Sprintf(
+			"%s: %d failures, skipped until %s",
+			h.Name, h.ConsecutiveFailures, h.CoolDownUntil.Local().Format("2006-01-02 15:04"),
+		)
+	case h.ConsecutiveFailures > 0:
+		return fmt.Sprintf("%s: %d failures", h.Name, h.ConsecutiveFailures)
+	default:
+		return fmt.Sprintf("%s: ok", h.Name)
+	}
+}
+
+// statusReport is the json output of the status command
+type statusReport struct {
+	// Daemon is nil if no daemon is running
+	Daemon   *DaemonStatus
+	Channels []history.Health
+}
+
+func status() error {
+	if statusFormat != "text" && statusFormat != "json" {
+		return fmt.Errorf("unknown output format %s", statusFormat)
+	}
+	all, err := sortedHealth()
+	if err != nil {
+		return err
+	}
+	report := statusReport{Channels: all}
+	if resp, err := sendControl(controlRequest{Command: controlStatus}); err == nil {
+		report.Daemon = resp.Status
+	} else if !errors.Is(err, errNoDaemon) {
+		logrus.WithError(err).Warn("cannot query the running daemon")
+	}
+
+	if statusFormat == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	describeDaemon(report.Daemon)
+	if len(all) == 0 {
+		fmt.Println("no channel has been tried yet")
+		return nil
+	}
+	now := time.Now()
+	for _, h := range all {
+		fmt.Println(describeHealth(h, now))
+		if !h.LastSuccess.IsZero() {
+			fmt.Printf("    last success: %s\n", h.LastSuccess.Local().Format(time.RFC3339))
+		}
+		if h.LastError != "" {
+			fmt.Printf("    last error:   %s (%s)\n", h.LastError, h.LastFailure.Local().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func describeDaemon(d *DaemonStatus) {
+	if d == nil {
+		fmt.Println("daemon: not running")
+		return
+	}
+	state := "running"
+	if d.Paused {
+		state = "paused"
+	}
+	fmt.Printf("daemon: %s (pid %d, since %s)\n", state, d.PID, d.StartedAt.Local().Format(time.RFC3339))
+	if d.Profile != "" {
+		fmt.Printf("    profile:      %s\n", d.Profile)
+	}
+	if !d.NextCycle.IsZero() {
+		fmt.Printf("    next refresh: %s\n", d.NextCycle.Local().Format(time.RFC3339))
+	}
+	if !d.LastRefresh.IsZero() {
+		fmt.Printf("    last refresh: %s\n", d.LastRefresh.Local().Format(time.RFC3339))
+	}
+	if d.LastMeta != nil {
+		fmt.Printf("    wallpaper:    %s (%s)\n", d.LastMeta.Title, d.LastMeta.ChannelKey)
+	}
+	if d.LastError != "" {
+		fmt.Printf("    last error:   %s\n", d.LastError)
+	}
+}
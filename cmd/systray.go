@@ -1,16 +1,45 @@
+//go:build !headless
+// +build !headless
+
 package cmd
 
 import (
 	"github.com/genzj/goTApaper/install"
 	"io/ioutil"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/genzj/goTApaper/channel"
+	"github.com/genzj/goTApaper/config"
 	"github.com/genzj/goTApaper/data"
 	"github.com/getlantern/systray"
 	"github.com/sirupsen/logrus"
 )
 
+// runSystray starts the daemon with a systray menu, blocking until the user
+// quits from the menu
+func runSystray() {
+	logrus.Infoln("starting daemon...")
+	systray.Run(onReady, onExit)
+}
+
+func onReady() {
+	nextCycleCh := newNextCycleChannel()
+	nextCycle := newNextCycleTrigger(nextCycleCh)
+
+	callback := initSystray(nextCycle)
+	initDaemon(daemonCtx, nextCycleWaitChannel(nextCycleCh), callback)
+	serveControl(daemonCtx, nextCycleCh)
+	startAPI(daemonCtx, nextCycleCh)
+	nextCycle(nil)
+}
+
+func onExit() {
+	// clean up here
+	daemonCancel()
+}
+
 func mustReadIcon(name string) []byte {
 	file, err := data.ExampleAssets.Open("/assets/" + name)
 	if err != nil {
@@ -49,6 +78,29 @@ func initSystray(nextCycle nextCycleTrigger) cycleUpdateCallback {
 	mChannel.Hide()
 	mUpdateTime.Hide()
 
+	mHealth := systray.AddMenuItem("Channel health", "Recent failures of channels")
+	healthItems := make(map[string]*systray.MenuItem)
+	updateHealth := func() {
+		all, err := sortedHealth()
+		if err != nil {
+			logrus.WithError(err).Warn("cannot load channel health")
+			return
+		}
+		now := time.Now()
+		for _, h := range all {
+			item, ok := healthItems[h.Name]
+			if !ok {
+				item = mHealth.AddSubMenuItem("", h.LastError)
+				item.Disable()
+				healthItems[h.Name] = item
+			}
+			item.SetTitle(describeHealth(h, now))
+			item.SetTooltip(h.LastError)
+		}
+	}
+	updateHealth()
+	initProfileMenu()
+
 	systray.AddSeparator()
 
 	mStartup := systray.AddMenuItemCheckbox(
@@ -77,6 +129,8 @@ func initSystray(nextCycle nextCycleTrigger) cycleUpdateCallback {
 				}
 			case <-mQuitOrig.ClickedCh:
 				logrus.Debugln("Requesting quit")
+				// abort in-flight downloads before the systray loop exits
+				daemonCancel()
 				systray.Quit()
 			case <-mRefresh.ClickedCh:
 				logrus.Debugln("Requesting refresh")
@@ -119,6 +173,74 @@ func initSystray(nextCycle nextCycleTrigger) cycleUpdateCallback {
 		case stagePostRefresh:
 			mRefresh.SetTitle("Refresh")
 			mRefresh.Enable()
+			updateHealth()
+		}
+	}
+}
+
+// initProfileMenu adds the submenu switching profiles. Items of profiles
+// defined later are added on config changes, and those removed are hidden
+func initProfileMenu() {
+	mProfile := systray.AddMenuItem("Profile", "Switch the profile")
+	mAuto := mProfile.AddSubMenuItemCheckbox("Automatic", "Choose the profile by profile rules", false)
+	items := make(map[string]*systray.MenuItem)
+
+	var l sync.Mutex
+	var update func()
+	selectProfile := func(name string) {
+		if err := config.SelectProfile(name); err != nil {
+			logrus.WithError(err).WithField("profile", name).Warn("cannot switch profile")
+		}
+		// the profile in effect may stay, e.g. when chosen automatically
+		update()
+	}
+	go func() {
+		for range mAuto.ClickedCh {
+			selectProfile("")
+		}
+	}()
+
+	update = func() {
+		l.Lock()
+		defer l.Unlock()
+		defined := make(map[string]bool)
+		for _, name := range config.Profiles() {
+			defined[name] = true
+			if _, ok := items[name]; !ok {
+				item := mProfile.AddSubMenuItemCheckbox(name, "", false)
+				items[name] = item
+				go func(name string) {
+					for range item.ClickedCh {
+						selectProfile(name)
+					}
+				}(name)
+			}
+		}
+		active, selected := config.ActiveProfile(), config.SelectedProfile()
+		for name, item := range items {
+			if !defined[name] {
+				item.Hide()
+				continue
+			}
+			item.Show()
+			if name == active {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+		}
+		if selected == "" {
+			mAuto.Check()
+		} else {
+			mAuto.Uncheck()
+		}
+		if len(defined) == 0 {
+			mProfile.Hide()
+		} else {
+			mProfile.Show()
 		}
 	}
+	update()
+	config.Observe(config.ProfileKey, func(string, interface{}, interface{}) { update() })
+	config.Observe(config.ProfilesKey+".*", func(string, interface{}, interface{}) { update() })
 }
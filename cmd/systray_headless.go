@@ -0,0 +1,13 @@
+//go:build headless
+// +build headless
+
+package cmd
+
+import "github.com/sirupsen/logrus"
+
+// runSystray falls back to the headless daemon, as binaries built with the
+// headless tag have no systray
+func runSystray() {
+	logrus.Infoln("built without systray, starting headless daemon...")
+	headless()
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 
@@ -19,7 +21,7 @@ func initLogger() {
 	logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
 	//logrus.SetOutput(file)
 
-	if viper.GetBool("debug") {
+	if util.Settings.GetBool("debug") {
 		logrus.SetLevel(logrus.DebugLevel)
 		logrus.Debugln("Debug log enabled")
 	} else {
@@ -73,21 +75,93 @@ func SaveConfig() error {
 
 // SaveYaml dumps configuration into a YAML file
 func SaveYaml(fn string) error {
-	f, err := os.Create(fn)
-	if err != nil {
-		logrus.WithField("filename", fn).Error("cannot open configuration file for writing")
+	conf := map[string]interface{}{}
+
+	if err := util.Settings.Unmarshal(&conf); err != nil {
+		logrus.Error("cannot dump configuration structure")
 		logrus.Error(err)
 		return err
 	}
-	defer f.Close()
+	return writeYaml(fn, conf)
+}
 
-	conf := map[string]interface{}{}
+// UpdateConfig writes the settings into the config file and reloads it, so
+// that observers are notified of changed keys. Keys can be nested ones like
+// daemon.interval. Settings overridden by the profile in effect are written
+// to the profile, see EditKey. Comments and anchors of the file are kept
+// except in the changed values
+func UpdateConfig(settings map[string]interface{}) error {
+	fn := viper.ConfigFileUsed()
+	if fn == "" {
+		return fmt.Errorf("Configuration file not specified")
+	}
 
-	if err = viper.Unmarshal(&conf); err != nil {
-		logrus.Error("cannot dump configuration structure")
+	doc, err := LoadDocument(fn)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := updateDocument(doc, key, EditKey(key), settings[key]); err != nil {
+			return fmt.Errorf("cannot set %s: %w", key, err)
+		}
+	}
+
+	if err := doc.Save(fn); err != nil {
+		return err
+	}
+	return Reload()
+}
+
+// updateDocument sets the value of target in the document if the setting of
+// key, which target holds, differs from the value. Mappings are updated key
+// by key, so that comments and anchors in them are kept
+func updateDocument(doc *Document, key, target string, value interface{}) error {
+	if sameValue(util.Settings.Get(key), value) {
+		return nil
+	}
+	m, ok := value.(map[string]interface{})
+	existing, isMapping := doc.Keys(target)
+	if !ok || !isMapping {
+		return doc.Set(target, value)
+	}
+
+	for _, name := range existing {
+		found := false
+		for sub := range m {
+			found = found || strings.EqualFold(sub, name)
+		}
+		if !found {
+			if err := doc.Delete(target + "." + name); err != nil {
+				return err
+			}
+		}
+	}
+	subs := make([]string, 0, len(m))
+	for sub := range m {
+		subs = append(subs, sub)
+	}
+	sort.Strings(subs)
+	for _, sub := range subs {
+		if err := updateDocument(doc, key+"."+sub, target+"."+sub, m[sub]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeYaml(fn string, conf map[string]interface{}) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		logrus.WithField("filename", fn).Error("cannot open configuration file for writing")
 		logrus.Error(err)
 		return err
 	}
+	defer f.Close()
 
 	bs, err := yaml.Marshal(conf)
 	if err != nil {
@@ -128,7 +202,7 @@ func LoadConfig(cfgFile string) {
 	initLogger()
 	logrus.Debugln("config file searching folder: ", util.ExecutableFolder())
 	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
+	if err := ReadInConfig(); err == nil {
 		logrus.Debugln("Using config file:", viper.ConfigFileUsed())
 	} else if cfgFile != "" {
 		logrus.WithFields(logrus.Fields{
@@ -138,9 +212,11 @@ func LoadConfig(cfgFile string) {
 		logrus.Debugln("No config file found, use default settings")
 	}
 	initLogger() // intentionally repeat, in case config file updates settings
-	logrus.Debugf("%+v", viper.AllSettings())
+	logrus.Debugf("%+v", util.MaskSecrets(util.Settings.AllSettings()))
 
 	Observe("debug", func(_ string, _, _ interface{}) {
 		initLogger()
 	})
+	// snapshot for later changes
+	EmitChanges()
 }
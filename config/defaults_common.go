@@ -11,8 +11,67 @@ const (
 	// DefaultHistoryFileName specifies default name of the history file
 	DefaultHistoryFileName = "history.json"
 
+	// DefaultHealthFileName specifies default name of the channel health file
+	DefaultHealthFileName = "health.json"
+
+	// DefaultHTTPCacheDirName specifies default name of the HTTP cache folder
+	DefaultHTTPCacheDirName = "http-cache"
+
+	// DefaultHTTPCacheMaxSize specifies default size cap of the HTTP cache in bytes
+	DefaultHTTPCacheMaxSize = 16 << 20
+
+	// DefaultDownloadMaxSize specifies default size limit of a downloaded picture in bytes
+	DefaultDownloadMaxSize = 64 << 20
+
+	// DefaultDownloadMaxPixels specifies default limit of width*height of a
+	// picture to be decoded
+	DefaultDownloadMaxPixels = 50000000
+
 	// DefaultDaemonInterval specifies default daemon downloading interval
 	DefaultDaemonInterval = 3600
+
+	// DefaultArchiveDirName specifies default folder name of recent
+	// wallpapers
+	DefaultArchiveDirName = "archive"
+
+	// DefaultArchiveSize specifies default number of recent wallpapers
+	// archived
+	DefaultArchiveSize = 20
+
+	// DefaultFavouritesDirName specifies default folder name of favourite
+	// pictures
+	DefaultFavouritesDirName = "favourites"
+
+	// DefaultAPIListen specifies default address of the REST API, which must
+	// be a loopback address
+	DefaultAPIListen = "127.0.0.1:8765"
+
+	// DefaultInstanceLockFileName specifies file name of the daemon lock
+	DefaultInstanceLockFileName = "daemon.lock"
+
+	// DefaultControlSocketName specifies file name of the daemon control
+	// socket
+	DefaultControlSocketName = "daemon.sock"
+
+	// DefaultNetworkProbeURL is requested to confirm network connectivity
+	// before the daemon refreshes
+	DefaultNetworkProbeURL = "https://www.bing.com/"
+
+	// DefaultRefreshTimeout specifies default seconds allowed for a whole
+	// refresh, including trying all active channels
+	DefaultRefreshTimeout = 600
+
+	// DefaultChannelTimeout specifies default seconds allowed for a single
+	// channel to download its picture
+	DefaultChannelTimeout = 120
+
+	// DefaultPrefetchDirName specifies default folder name of prefetched
+	// pictures
+	DefaultPrefetchDirName = "prefetch"
+
+	// DefaultPrefetchMaxAge specifies default seconds a prefetched picture
+	// stays usable
+	DefaultPrefetchMaxAge = 86400
 )
 
 // InitDefaultConfig creates default configuration
@@ -21,7 +80,34 @@ func InitDefaultConfig() {
 	viper.SetDefault("debug", false)
 	viper.SetDefault("proxy", "direct")
 	viper.SetDefault("daemon.interval", 3600)
+	viper.SetDefault("archive.size", DefaultArchiveSize)
+	viper.SetDefault("api.enabled", false)
+	viper.SetDefault("api.listen", DefaultAPIListen)
+	viper.SetDefault("api.token", "")
+	viper.SetDefault("daemon.network-probe.url", DefaultNetworkProbeURL)
+	viper.SetDefault("daemon.network-probe.interval", 10)
+	viper.SetDefault("daemon.network-probe.max-wait", 300)
+	viper.SetDefault("refresh-timeout", DefaultRefreshTimeout)
+	viper.SetDefault("channel-timeout", DefaultChannelTimeout)
+	viper.SetDefault("health.threshold", 3)
+	viper.SetDefault("health.base-cool-down", 3600)
+	viper.SetDefault("health.max-cool-down", 86400)
+	viper.SetDefault("http-cache.enabled", true)
+	viper.SetDefault("http-cache.max-size", DefaultHTTPCacheMaxSize)
+	viper.SetDefault("http-cache.offline-fallback", true)
+	viper.SetDefault("download.max-size", DefaultDownloadMaxSize)
+	viper.SetDefault("download.max-pixels", DefaultDownloadMaxPixels)
+	viper.SetDefault("retry.attempts", 3)
+	viper.SetDefault("retry.initial-delay", 1.0)
+	viper.SetDefault("retry.max-delay", 30.0)
+	viper.SetDefault("retry.multiplier", 2.0)
+	viper.SetDefault("retry.jitter", 0.2)
+	viper.SetDefault("prefetch.enabled", false)
+	viper.SetDefault("prefetch.size", 3)
+	viper.SetDefault("prefetch.concurrency", 2)
+	viper.SetDefault("prefetch.max-age", DefaultPrefetchMaxAge)
 	viper.SetDefault("active-channels", []string{"__ng-photo-of-today", "__bing-wallpaper"})
+	viper.SetDefault("channel-selection.policy", "ordered")
 	viper.SetDefault("channels", []string{"__ng-photo-of-today", "__bing-wallpaper"})
 	viper.SetDefault("channels.__ng-photo-of-today.type", "ng-photo-of-today")
 	viper.SetDefault("channels.__bing-wallpaper.strategy", "largest-no-logo")
@@ -29,5 +115,6 @@ func InitDefaultConfig() {
 	viper.SetDefault("reference-width", 1920)
 	viper.SetDefault("reference-height", 1080)
 	viper.SetDefault("crop", "yes")
+	viper.SetDefault("pipeline", []string{"crop", "watermark"})
 	viper.SetDefault("setter", DefaultSetter)
 }
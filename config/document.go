@@ -0,0 +1,544 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+// Document edits a YAML config file as text, so that comments, blank lines
+// and anchors outside of the changed entries are kept as they are. Parts of
+// the file the edits cannot keep, e.g. flow style collections, are rendered
+// again
+type Document struct {
+	lines []string
+	root  *yamlv3.Node
+}
+
+// block is a block style mapping or sequence met while walking down a path,
+// whose children are after line start and up to line end (1-based,
+// inclusive)
+type block struct {
+	node       *yamlv3.Node
+	start, end int
+}
+
+// child is an entry of a block mapping or an item of a block sequence, taking
+// lines from start to end
+type child struct {
+	key, value *yamlv3.Node
+	start, end int
+	// indent is the column of the key, or of the dash of the item
+	indent int
+}
+
+// LoadDocument reads the YAML file for editing
+func LoadDocument(fn string) (*Document, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDocument(data)
+}
+
+// ParseDocument parses the YAML data for editing. Only the first document of
+// a multi-document stream can be edited
+func ParseDocument(data []byte) (*Document, error) {
+	d := &Document{}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text != "" {
+		d.lines = strings.Split(text, "\n")
+	}
+	return d, d.parse()
+}
+
+func (d *Document) parse() error {
+	doc := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(d.Bytes(), doc); err != nil {
+		return err
+	}
+	d.root = nil
+	if doc.Kind == yamlv3.DocumentNode && len(doc.Content) > 0 {
+		d.root = doc.Content[0]
+	}
+	return nil
+}
+
+// Bytes returns content of the edited document
+func (d *Document) Bytes() []byte {
+	if len(d.lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(d.lines, "\n") + "\n")
+}
+
+// Save writes the document into the file, keeping its permission
+func (d *Document) Save(fn string) error {
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(fn); err == nil {
+		mode = stat.Mode().Perm()
+	}
+	return os.WriteFile(fn, d.Bytes(), mode)
+}
+
+// replaceLines replaces lines from and to (1-based, inclusive), or inserts
+// before line from if to is from-1
+func (d *Document) replaceLines(from, to int, lines []string) error {
+	replaced := append([]string{}, d.lines[:from-1]...)
+	replaced = append(replaced, lines...)
+	replaced = append(replaced, d.lines[to:]...)
+	d.lines = replaced
+	return d.parse()
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// trimEnd drops blank lines and comments not indented deeper than indent from
+// the end of range, which belong to what follows
+func (d *Document) trimEnd(start, end, indent int) int {
+	for end > start && isComment(d.lines[end-1]) && indentOf(d.lines[end-1]) <= indent {
+		end--
+	}
+	return end
+}
+
+func isBlock(node *yamlv3.Node) bool {
+	return (node.Kind == yamlv3.MappingNode || node.Kind == yamlv3.SequenceNode) &&
+		node.Style&yamlv3.FlowStyle == 0 && len(node.Content) > 0
+}
+
+func isEmptyValue(node *yamlv3.Node) bool {
+	return node.Kind == yamlv3.ScalarNode && node.ShortTag() == "!!null" && node.Value == ""
+}
+
+// itemStart returns the line of the dash of a sequence item, which can be
+// above the item if the dash is on a line of its own
+func (d *Document) itemStart(item *yamlv3.Node, after, dash int) int {
+	for l := item.Line - 1; l > after; l-- {
+		line := d.lines[l-1]
+		if strings.TrimSpace(line) == "-" && indentOf(line) == dash {
+			return l
+		}
+		if !isComment(line) {
+			break
+		}
+	}
+	return item.Line
+}
+
+// children returns entries or items of the block with their lines
+func (d *Document) children(b block) []child {
+	var children []child
+	if b.node.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(b.node.Content); i += 2 {
+			k := b.node.Content[i]
+			children = append(children, child{
+				key: k, value: b.node.Content[i+1], start: k.Line, indent: k.Column - 1,
+			})
+		}
+	} else {
+		dash := b.node.Column - 1
+		after := b.start
+		for _, item := range b.node.Content {
+			start := d.itemStart(item, after, dash)
+			children = append(children, child{value: item, start: start, indent: dash})
+			after = start
+		}
+	}
+	for i := range children {
+		end := b.end
+		if i+1 < len(children) {
+			end = children[i+1].start - 1
+		}
+		children[i].end = d.trimEnd(children[i].start, end, children[i].indent)
+	}
+	return children
+}
+
+// lookup finds the child by explicit key, ignoring case like viper, or by
+// index
+func (d *Document) lookup(b block, name string) (child, bool) {
+	children := d.children(b)
+	if b.node.Kind == yamlv3.SequenceNode {
+		idx, err := strconv.Atoi(name)
+		if err != nil || idx < 0 || idx >= len(children) {
+			return child{}, false
+		}
+		return children[idx], true
+	}
+	for _, c := range children {
+		if strings.EqualFold(c.key.Value, name) && c.key.Tag != "!!merge" {
+			return c, true
+		}
+	}
+	return child{}, false
+}
+
+// walk returns blocks along the path, the last of which holds the deepest
+// existing part of the path at the returned depth
+func (d *Document) walk(path []string) ([]block, int, error) {
+	if d.root == nil || isEmptyValue(d.root) {
+		return nil, 0, nil
+	}
+	if d.root.Kind != yamlv3.MappingNode || !isBlock(d.root) {
+		return nil, 0, errors.New("top level of config file must be a block mapping")
+	}
+	levels := []block{{node: d.root, end: len(d.lines)}}
+	for depth := 0; depth < len(path)-1; depth++ {
+		c, ok := d.lookup(levels[len(levels)-1], path[depth])
+		if !ok || !isBlock(c.value) {
+			return levels, depth, nil
+		}
+		levels = append(levels, block{node: c.value, start: c.start, end: c.end})
+	}
+	return levels, len(path) - 1, nil
+}
+
+// find returns the child at the path, if it exists
+func (d *Document) find(path []string) (child, bool, error) {
+	levels, depth, err := d.walk(path)
+	if err != nil || levels == nil || depth < len(path)-1 {
+		return child{}, false, err
+	}
+	c, ok := d.lookup(levels[len(levels)-1], path[depth])
+	return c, ok, nil
+}
+
+// render encodes the value as lines indented by indent spaces
+func render(value interface{}, indent int) ([]string, error) {
+	buf := &bytes.Buffer{}
+	encoder := yamlv3.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	prefix := strings.Repeat(" ", indent)
+	for i := range lines {
+		if lines[i] != "" {
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return lines, nil
+}
+
+// pair builds a mapping of the only key, keeping the key as given
+func pair(key string, value interface{}) (*yamlv3.Node, error) {
+	v := &yamlv3.Node{}
+	if err := v.Encode(value); err != nil {
+		return nil, err
+	}
+	k := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}
+	return &yamlv3.Node{Kind: yamlv3.MappingNode, Content: []*yamlv3.Node{k, v}}, nil
+}
+
+// nested builds the value of path ending with value as nested mappings
+func nested(path []string, value interface{}) (interface{}, error) {
+	for i := len(path) - 1; i >= 0; i-- {
+		node, err := pair(path[i], value)
+		if err != nil {
+			return nil, err
+		}
+		value = node
+	}
+	return value, nil
+}
+
+// setIn sets the value in a decoded YAML value, creating mappings on the way
+func setIn(container interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	switch c := container.(type) {
+	case map[string]interface{}:
+		for key := range c {
+			if strings.EqualFold(key, path[0]) {
+				v, err := setIn(c[key], path[1:], value)
+				c[key] = v
+				return c, err
+			}
+		}
+		v, err := setIn(nil, path[1:], value)
+		c[path[0]] = v
+		return c, err
+	case []interface{}:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 || idx >= len(c) {
+			return c, fmt.Errorf("invalid index %s of a list of %d items", path[0], len(c))
+		}
+		v, err := setIn(c[idx], path[1:], value)
+		c[idx] = v
+		return c, err
+	default:
+		return setIn(map[string]interface{}{}, path, value)
+	}
+}
+
+// rewrite renders the whole child again with the value at path inside it
+// changed
+func (d *Document) rewrite(c child, path []string, value interface{}) error {
+	var current interface{}
+	if err := c.value.Decode(&current); err != nil {
+		return err
+	}
+	updated, err := setIn(current, path, value)
+	if err != nil {
+		return err
+	}
+	var node interface{} = []interface{}{updated}
+	if c.key != nil {
+		if node, err = pair(c.key.Value, updated); err != nil {
+			return err
+		}
+	}
+	lines, err := render(node, c.indent)
+	if err != nil {
+		return err
+	}
+	return d.replaceLines(c.start, c.end, lines)
+}
+
+// replaceInline replaces a value on the line of its key or dash, keeping its
+// anchor and comment. The new value must be a scalar or an empty collection.
+// It returns false if the value cannot be replaced in place
+func (d *Document) replaceInline(c child, value interface{}) (bool, error) {
+	v := c.value
+	inline := v.Kind == yamlv3.AliasNode ||
+		(v.Kind == yamlv3.ScalarNode && v.Style&(yamlv3.LiteralStyle|yamlv3.FoldedStyle) == 0)
+	if !inline || v.Line != c.start {
+		return false, nil
+	}
+	text, err := render(value, 0)
+	if err != nil || len(text) != 1 {
+		return false, err
+	}
+	if v.Anchor != "" {
+		text[0] = "&" + v.Anchor + " " + text[0]
+	}
+
+	line := d.lines[v.Line-1]
+	start, end := v.Column-1, len(line)
+	var comments []string
+	if c.key != nil {
+		comments = append(comments, c.key.LineComment)
+	}
+	for _, comment := range append(comments, v.LineComment) {
+		if pos := strings.LastIndex(line, comment); comment != "" && pos >= start {
+			end = pos
+		}
+	}
+	tail := line[end:]
+	if tail != "" {
+		tail = " " + tail
+	}
+	if isEmptyValue(v) {
+		text[0] = " " + text[0]
+	}
+	d.lines[v.Line-1] = line[:start] + text[0] + tail
+	if err := d.parse(); err != nil {
+		// e.g. a multi-line quoted scalar, restore and render it again
+		d.lines[v.Line-1] = line
+		return false, d.parse()
+	}
+	return true, nil
+}
+
+// insertAfter renders the value as lines below line after
+func (d *Document) insertAfter(after int, value interface{}, indent int) error {
+	lines, err := render(value, indent)
+	if err != nil {
+		return err
+	}
+	return d.replaceLines(after+1, after, lines)
+}
+
+// Set changes the value of the dotted key like daemon.interval, or adds it.
+// Items of lists can be changed with indexes like watermark.0.color
+func (d *Document) Set(key string, value interface{}) error {
+	path := strings.Split(key, ".")
+	levels, depth, err := d.walk(path)
+	if err != nil {
+		return err
+	} else if levels == nil {
+		node, err := nested(path, value)
+		if err != nil {
+			return err
+		}
+		return d.insertAfter(len(d.lines), node, 0)
+	}
+
+	b := levels[len(levels)-1]
+	c, ok := d.lookup(b, path[depth])
+	if !ok {
+		if b.node.Kind == yamlv3.SequenceNode {
+			return fmt.Errorf("invalid index %s of a list of %d items", path[depth], len(b.node.Content))
+		}
+		children := d.children(b)
+		node, err := nested(path[depth:], value)
+		if err != nil {
+			return err
+		}
+		last := children[len(children)-1]
+		return d.insertAfter(last.end, node, children[0].indent)
+	}
+
+	rest := path[depth+1:]
+	node := &yamlv3.Node{}
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+	switch {
+	case isEmptyValue(c.value) && c.key != nil && (len(rest) > 0 || isBlock(node)):
+		// keep comments below the key, usually options commented out
+		value, err := nested(rest, value)
+		if err != nil {
+			return err
+		}
+		return d.insertAfter(c.start, value, c.indent+2)
+	case len(rest) > 0:
+		return d.rewrite(c, rest, value)
+	}
+	if ok, err := d.replaceInline(c, value); ok || err != nil {
+		return err
+	}
+	return d.rewrite(c, nil, value)
+}
+
+// list finds the list of the dotted key. It returns false if the key is
+// missing or its value is empty
+func (d *Document) list(key string) (child, bool, error) {
+	c, ok, err := d.find(strings.Split(key, "."))
+	if err != nil || !ok || isEmptyValue(c.value) {
+		return c, false, err
+	}
+	if c.value.Kind != yamlv3.SequenceNode {
+		return c, false, fmt.Errorf("%s is not a list", key)
+	}
+	return c, true, nil
+}
+
+// Items returns items of the list of the dotted key. It returns false if the
+// key is missing or its value is empty
+func (d *Document) Items(key string) ([]interface{}, bool, error) {
+	c, ok, err := d.list(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var items []interface{}
+	if err := c.value.Decode(&items); err != nil {
+		return nil, false, err
+	}
+	return items, true, nil
+}
+
+// Append adds the item to the end of the list of the dotted key, creating
+// the list if missing
+func (d *Document) Append(key string, item interface{}) error {
+	c, ok, err := d.list(key)
+	if err != nil {
+		return err
+	} else if !ok {
+		return d.Set(key, []interface{}{item})
+	} else if !isBlock(c.value) {
+		var items []interface{}
+		if err := c.value.Decode(&items); err != nil {
+			return err
+		}
+		return d.Set(key, append(items, item))
+	}
+
+	items := d.children(block{node: c.value, start: c.start, end: c.end})
+	return d.insertAfter(items[len(items)-1].end, []interface{}{item}, items[0].indent)
+}
+
+// Remove deletes items of the list of the dotted key for which match returns
+// true, and returns the number of items removed
+func (d *Document) Remove(key string, match func(item interface{}) bool) (int, error) {
+	c, ok, err := d.list(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	var items []interface{}
+	if err := c.value.Decode(&items); err != nil {
+		return 0, err
+	}
+	var kept []interface{}
+	var removed []int
+	for idx, item := range items {
+		if match(item) {
+			removed = append(removed, idx)
+		} else {
+			kept = append(kept, item)
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	} else if !isBlock(c.value) {
+		return len(removed), d.Set(key, kept)
+	}
+
+	children := d.children(block{node: c.value, start: c.start, end: c.end})
+	// delete from the last one so that lines of earlier ones stay
+	for n := len(removed) - 1; n >= 0; n-- {
+		item := children[removed[n]]
+		d.lines = append(d.lines[:item.start-1], d.lines[item.end:]...)
+	}
+	if err := d.parse(); err != nil {
+		return 0, err
+	}
+	if len(kept) == 0 {
+		// the key is left without value, which means null
+		return len(removed), d.Set(key, []interface{}{})
+	}
+	return len(removed), nil
+}
+
+// Keys returns explicit keys of the block mapping of the dotted key, or of
+// the top level if key is empty. It returns false if the value is not a
+// block mapping
+func (d *Document) Keys(key string) ([]string, bool) {
+	mapping := d.root
+	if key != "" {
+		c, ok, err := d.find(strings.Split(key, "."))
+		if err != nil || !ok {
+			return nil, false
+		}
+		mapping = c.value
+	}
+	if mapping == nil || mapping.Kind != yamlv3.MappingNode || !isBlock(mapping) {
+		return nil, false
+	}
+	var keys []string
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Tag != "!!merge" {
+			keys = append(keys, mapping.Content[i].Value)
+		}
+	}
+	return keys, true
+}
+
+// Delete removes the dotted key with its value, or the indexed list item, if
+// it is set explicitly
+func (d *Document) Delete(key string) error {
+	c, ok, err := d.find(strings.Split(key, "."))
+	if err != nil || !ok {
+		return err
+	}
+	return d.replaceLines(c.start, c.end, nil)
+}
@@ -0,0 +1,317 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/genzj/goTApaper/util"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	yamlv3 "go.yaml.in/yaml/v3"
+)
+
+// IncludeKey lists files, or glob patterns of files, merged under the file
+// declaring it
+const IncludeKey = "include"
+
+// Tree is the result of merging a config file with its includes and
+// overlays, in increasing precedence:
+//
+//  1. files included by the config file, in the listed order, each after
+//     its own includes
+//  2. the config file itself
+//  3. config.<GOOS>.yaml next to the config file
+//  4. config.<hostname>.yaml next to the config file, the short hostname
+//     before the full one
+//
+// ReadInConfig merges the profile in effect over all of them.
+// Mappings are merged key by key, while lists and other values are replaced
+// as a whole
+type Tree struct {
+	Settings map[string]interface{}
+	// Files are all files read, in the order of merging
+	Files []string
+	// Sources map flattened keys like daemon.interval to their files
+	Sources map[string]string
+	// patterns are absolute include patterns, whose new matches change
+	// the tree
+	patterns []string
+}
+
+// loaded is the tree of the config file in use
+var loaded = struct {
+	l    sync.RWMutex
+	tree *Tree
+}{}
+
+// OverlayFiles returns candidates of files overlaying the config file, in
+// increasing precedence. They don't need to exist
+func OverlayFiles(fn string) []string {
+	if abs, err := filepath.Abs(fn); err == nil {
+		fn = abs
+	}
+	ext := filepath.Ext(fn)
+	base := strings.TrimSuffix(fn, ext)
+	names := []string{runtime.GOOS}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		short, _, _ := strings.Cut(host, ".")
+		names = append(names, short)
+		if host != short {
+			names = append(names, host)
+		}
+	}
+	files := make([]string, 0, len(names))
+	for _, name := range names {
+		files = append(files, base+"."+strings.ToLower(name)+ext)
+	}
+	return files
+}
+
+// LoadTree merges the config file with its includes and overlays. The file
+// is read from data if it is not nil
+func LoadTree(fn string, data []byte) (*Tree, error) {
+	fn, err := filepath.Abs(fn)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{Settings: map[string]interface{}{}, Sources: map[string]string{}}
+	if err := t.mergeFile(fn, data, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	for _, overlay := range OverlayFiles(fn) {
+		if _, err := os.Stat(overlay); err != nil {
+			continue
+		}
+		if err := t.mergeFile(overlay, nil, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *Tree) mergeFile(fn string, data []byte, visiting map[string]bool) error {
+	if visiting[fn] {
+		return fmt.Errorf("%s includes itself", fn)
+	}
+	visiting[fn] = true
+	defer delete(visiting, fn)
+
+	if data == nil {
+		var err error
+		if data, err = os.ReadFile(fn); err != nil {
+			return err
+		}
+	}
+	settings := map[string]interface{}{}
+	if err := yamlv3.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+
+	var includes interface{}
+	for key, value := range settings {
+		if strings.EqualFold(key, IncludeKey) {
+			includes = value
+			delete(settings, key)
+		}
+	}
+	patterns, err := includePatterns(includes)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	for _, pattern := range patterns {
+		if pattern, err = homedir.Expand(pattern); err != nil {
+			return fmt.Errorf("%s: %w", fn, err)
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(fn), pattern)
+		}
+		files := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			t.patterns = append(t.patterns, pattern)
+			if files, err = filepath.Glob(pattern); err != nil {
+				return fmt.Errorf("%s: invalid include %s: %w", fn, pattern, err)
+			}
+			sort.Strings(files)
+			if len(files) == 0 {
+				logrus.WithField("file", fn).WithField("pattern", pattern).Debug("no file matches the include pattern")
+			}
+		}
+		for _, include := range files {
+			if err := t.mergeFile(include, nil, visiting); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.Files = append(t.Files, fn)
+	mergeSettings(t.Settings, settings, "", fn, t.Sources)
+	return nil
+}
+
+func includePatterns(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		patterns := make([]string, 0, len(v))
+		for _, item := range v {
+			pattern, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of file names", IncludeKey)
+			}
+			patterns = append(patterns, pattern)
+		}
+		return patterns, nil
+	}
+	return nil, fmt.Errorf("%s must be a file name or a list of them", IncludeKey)
+}
+
+// mergeSettings merges src into dst with keys lower cased like viper, and
+// records the source of each leaf key
+func mergeSettings(dst, src map[string]interface{}, prefix, source string, sources map[string]string) {
+	for key, value := range src {
+		key = strings.ToLower(key)
+		full := prefix + key
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeSettings(dstMap, srcMap, full+".", source, sources)
+			continue
+		}
+		for k := range sources {
+			if k == full || strings.HasPrefix(k, full+".") {
+				delete(sources, k)
+			}
+		}
+		if srcIsMap {
+			dstMap = map[string]interface{}{}
+			dst[key] = dstMap
+			mergeSettings(dstMap, srcMap, full+".", source, sources)
+			if len(srcMap) == 0 {
+				sources[full] = source
+			}
+			continue
+		}
+		dst[key] = value
+		sources[full] = source
+	}
+}
+
+// ReadInConfig reads the config file in use with its includes and overlays,
+// then applies the profile in effect. Current settings are kept if any of
+// the files is broken, and replaced as a whole under util.Settings otherwise
+func ReadInConfig() error {
+	fn := viper.ConfigFileUsed()
+	if fn == "" {
+		// let viper search for the config file
+		err := util.Settings.Update(func() error {
+			return viper.ReadInConfig()
+		})
+		if err != nil {
+			return err
+		}
+		fn = viper.ConfigFileUsed()
+	}
+	t, err := LoadTree(fn, nil)
+	if err != nil {
+		return err
+	}
+	t.applyProfile()
+	err = util.Settings.Update(func() error {
+		if err := viper.ReadConfig(strings.NewReader("")); err != nil {
+			return err
+		}
+		return viper.MergeConfigMap(t.Settings)
+	})
+	if err != nil {
+		return err
+	}
+	if len(t.Files) > 1 {
+		logrus.WithField("files", t.Files).Debug("config files merged")
+	}
+
+	loaded.l.Lock()
+	defer loaded.l.Unlock()
+	loaded.tree = t
+	return nil
+}
+
+// LoadedTree returns the tree of the config file in use, or nil if no config
+// file is read
+func LoadedTree() *Tree {
+	loaded.l.RLock()
+	defer loaded.l.RUnlock()
+	return loaded.tree
+}
+
+// dependsOn tells if changes of the file change the tree of the config file
+func (t *Tree) dependsOn(fn string, overlays []string) bool {
+	for _, f := range append(append([]string{}, t.Files...), overlays...) {
+		if f == fn {
+			return true
+		}
+	}
+	for _, pattern := range t.patterns {
+		if ok, _ := filepath.Match(pattern, fn); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedDirs returns folders holding files the tree depends on
+func (t *Tree) watchedDirs(overlays []string) []string {
+	seen := map[string]bool{}
+	var dirs []string
+	files := append(append([]string{}, t.Files...), overlays...)
+	files = append(files, t.patterns...)
+	for _, f := range files {
+		dir := filepath.Dir(f)
+		if !seen[dir] && !strings.ContainsAny(dir, "*?[") {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// IncludedFrom returns the file included by or overlaying the config file in
+// use which the setting of the flattened key comes from, empty if the key
+// is not set by such a file
+func IncludedFrom(key string) string {
+	t := LoadedTree()
+	if t == nil {
+		return ""
+	}
+	fn, ok := t.Sources[strings.ToLower(key)]
+	if !ok || !filepath.IsAbs(fn) {
+		return ""
+	}
+	if main, err := filepath.Abs(viper.ConfigFileUsed()); err == nil && fn == main {
+		return ""
+	}
+	return fn
+}
+
+// Source describes where the flattened key like daemon.interval gets its
+// value: a config file, an environment variable or the default
+func Source(key string) string {
+	env := strings.ToUpper(getAppName() + "_" + key)
+	if _, ok := os.LookupEnv(env); ok {
+		return "env " + env
+	}
+	if t := LoadedTree(); t != nil {
+		if fn, ok := t.Sources[strings.ToLower(key)]; ok {
+			return fn
+		}
+	}
+	return "default"
+}
@@ -4,10 +4,10 @@ import (
 	"os"
 	"path"
 
+	"github.com/genzj/goTApaper/util"
 	"github.com/sirupsen/logrus"
 
 	homedir "github.com/mitchellh/go-homedir"
-	"github.com/spf13/viper"
 )
 
 const (
@@ -15,11 +15,21 @@ const (
 	WallpaperFileSettingName = "wallpaper-file-name"
 	// HistoryFileSettingName in config file
 	HistoryFileSettingName = "history-file"
+	// HealthFileSettingName in config file
+	HealthFileSettingName = "health-file"
+	// HTTPCacheDirSettingName in config file
+	HTTPCacheDirSettingName = "http-cache.dir"
+	// PrefetchDirSettingName in config file
+	PrefetchDirSettingName = "prefetch.dir"
+	// ArchiveDirSettingName in config file
+	ArchiveDirSettingName = "archive.dir"
+	// FavouritesDirSettingName in config file
+	FavouritesDirSettingName = "favourites-dir"
 )
 
 func loadAppFileName(configKey, defaultValue string) string {
-	if viper.IsSet(configKey) {
-		filename := viper.GetString(configKey)
+	if util.Settings.IsSet(configKey) {
+		filename := util.Settings.GetString(configKey)
 		if filename != "" {
 			return MustExpand(filename)
 		}
@@ -39,6 +49,43 @@ func GetHistoryFileName() string {
 	return loadAppFileName(HistoryFileSettingName, DefaultHistoryFileName)
 }
 
+// GetHealthFileName return a proper path for channel health storage
+func GetHealthFileName() string {
+	return loadAppFileName(HealthFileSettingName, DefaultHealthFileName)
+}
+
+// GetHTTPCacheDir return a proper path for HTTP response cache
+func GetHTTPCacheDir() string {
+	return loadAppFileName(HTTPCacheDirSettingName, DefaultHTTPCacheDirName)
+}
+
+// GetPrefetchDir return a proper path for prefetched pictures
+func GetPrefetchDir() string {
+	return loadAppFileName(PrefetchDirSettingName, DefaultPrefetchDirName)
+}
+
+// GetArchiveDir return a proper path for copies of recent wallpapers
+func GetArchiveDir() string {
+	return loadAppFileName(ArchiveDirSettingName, DefaultArchiveDirName)
+}
+
+// GetFavouritesDir return a proper path for copies of favourite pictures
+func GetFavouritesDir() string {
+	return loadAppFileName(FavouritesDirSettingName, DefaultFavouritesDirName)
+}
+
+// GetInstanceLockFileName return the path of the lock file held by the
+// running daemon
+func GetInstanceLockFileName() string {
+	return path.Join(AppDir(), DefaultInstanceLockFileName)
+}
+
+// GetControlSocketName return the path of the unix domain socket to control
+// the running daemon
+func GetControlSocketName() string {
+	return path.Join(AppDir(), DefaultControlSocketName)
+}
+
 // MustExpand expands file paths with '~' or aborts whole app at failure
 func MustExpand(filename string) string {
 	l := logrus.WithField("filename", filename)
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	// ProfilesKey defines named profiles overriding some settings
+	ProfilesKey = "profiles"
+	// ProfileKey is the profile used when none is selected, and the profile
+	// in effect after reading the config
+	ProfileKey = "profile"
+)
+
+// ProfileKeys are settings a profile can override
+var ProfileKeys = []string{
+	"active-channels", "channel-selection", "watermark", "crop", "reference-width", "reference-height",
+}
+
+// profile keeps profiles chosen at runtime. selected is chosen by the user,
+// e.g. with --profile or from the systray, and wins over scheduled which is
+// chosen by time-of-day rules. The profile key of config file is used if
+// neither is set
+var profile = struct {
+	l         sync.Mutex
+	selected  string
+	scheduled string
+}{}
+
+// SelectProfile chooses the profile by the user, or returns to automatic
+// choosing if name is empty. Settings are reloaded and change events are
+// emitted if the profile in effect changes
+func SelectProfile(name string) error {
+	if name != "" && !hasProfile(name) {
+		return fmt.Errorf("profile %s not defined", name)
+	}
+	profile.l.Lock()
+	profile.selected = name
+	profile.l.Unlock()
+	return reloadProfile()
+}
+
+// ScheduleProfile sets the profile chosen by time-of-day rules, empty if no
+// rule matches. Settings are reloaded like SelectProfile
+func ScheduleProfile(name string) error {
+	profile.l.Lock()
+	changed := profile.scheduled != name
+	profile.scheduled = name
+	profile.l.Unlock()
+	if !changed {
+		return nil
+	}
+	return reloadProfile()
+}
+
+// SelectedProfile returns the profile chosen by the user, empty if choosing
+// automatically
+func SelectedProfile() string {
+	profile.l.Lock()
+	defer profile.l.Unlock()
+	return profile.selected
+}
+
+// ActiveProfile returns the profile in effect, empty if none
+func ActiveProfile() string {
+	return util.Settings.GetString(ProfileKey)
+}
+
+// Profiles returns sorted names of defined profiles
+func Profiles() []string {
+	names := make([]string, 0)
+	for name := range util.Settings.GetStringMap(ProfilesKey) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EditKey returns the key to change in the config file for the setting of
+// key: the one under the profile in effect if the profile overrides it, or
+// the key itself. Changing the base key would have no effect, or copy the
+// profile's value into the base if written back
+func EditKey(key string) string {
+	name := ActiveProfile()
+	if name == "" {
+		return key
+	}
+	top := strings.ToLower(strings.SplitN(key, ".", 2)[0])
+	for _, k := range ProfileKeys {
+		if k == top && util.Settings.IsSet(ProfilesKey+"."+name+"."+top) {
+			return ProfilesKey + "." + name + "." + key
+		}
+	}
+	return key
+}
+
+func hasProfile(name string) bool {
+	return util.Settings.IsSet(ProfilesKey + "." + name)
+}
+
+func reloadProfile() error {
+	if viper.ConfigFileUsed() == "" {
+		return errNoConfigFile
+	}
+	return Reload()
+}
+
+// applyProfile merges settings of the profile in effect over the tree, and
+// sets the profile key to its name
+func (t *Tree) applyProfile() {
+	profile.l.Lock()
+	name, source := profile.selected, "selected profile"
+	if name == "" {
+		name, source = profile.scheduled, "profile rules"
+	}
+	profile.l.Unlock()
+	if name == "" {
+		name, _ = t.Settings[ProfileKey].(string)
+		source = t.Sources[ProfileKey]
+	}
+	name = strings.ToLower(name)
+
+	profiles, _ := t.Settings[ProfilesKey].(map[string]interface{})
+	settings, ok := profiles[name].(map[string]interface{})
+	if name != "" && !ok {
+		logrus.WithField("profile", name).Warn("profile not defined, ignored")
+		name = ""
+	}
+	t.Settings[ProfileKey] = name
+	if source != "" {
+		t.Sources[ProfileKey] = source
+	}
+	if name == "" {
+		return
+	}
+
+	overrides := make(map[string]interface{}, len(ProfileKeys))
+	for _, key := range ProfileKeys {
+		if value, ok := settings[key]; ok {
+			overrides[key] = value
+		}
+	}
+	mergeSettings(t.Settings, overrides, "", "profile "+name, t.Sources)
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/genzj/goTApaper/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// reloadDelay is the quiet period after a change of config file before it is
+// reloaded, so that editors writing a file several times cause one reload
+const reloadDelay = 500 * time.Millisecond
+
+var errNoConfigFile = errors.New("no config file in use")
+
+// snapshot keeps settings seen by observers, flattened to leaf keys like
+// daemon.interval
+var snapshot = struct {
+	l        sync.Mutex
+	settings map[string]interface{}
+}{}
+
+func flattenSettings() map[string]interface{} {
+	keys := util.Settings.AllKeys()
+	settings := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		settings[key] = util.Settings.Get(key)
+	}
+	return settings
+}
+
+// sameValue compares settings loosely, so that e.g. a float default written
+// to the config file and read back as an integer is not a change
+func sameValue(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b) || fmt.Sprintf("%#v", a) == fmt.Sprintf("%#v", b) ||
+		fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// EmitChanges compares the settings with those of the last call, and emits an
+// event for each key added, removed or changed. The first call only takes a
+// snapshot
+func EmitChanges() {
+	snapshot.l.Lock()
+	defer snapshot.l.Unlock()
+
+	current := flattenSettings()
+	old := snapshot.settings
+	snapshot.settings = current
+	if old == nil {
+		return
+	}
+
+	changed := []string{}
+	for key, value := range current {
+		if !sameValue(old[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := current[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	for _, key := range changed {
+		logrus.WithField("key", key).Debug("config changed")
+		Emit(key, old[key], current[key])
+	}
+}
+
+// Reload rereads the config file and emits events of changed keys. Settings
+// are kept if the file is broken
+func Reload() error {
+	if viper.ConfigFileUsed() == "" {
+		return errNoConfigFile
+	}
+	if err := ReadInConfig(); err != nil {
+		return err
+	}
+	EmitChanges()
+	return nil
+}
+
+// WatchConfig reloads the config file whenever it, or any file included or
+// overlaying it, changes on disk. The config file is watched by the same
+// watcher as the others, rather than by viper, which would reload the bare
+// file without includes, overlays and the profile
+func WatchConfig() {
+	if viper.ConfigFileUsed() == "" {
+		logrus.Debug("no config file to watch")
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logrus.WithError(err).Error("cannot watch config files")
+		return
+	}
+	var l sync.Mutex
+	var timer *time.Timer
+	reload := func() {
+		l.Lock()
+		defer l.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(reloadDelay, func() {
+			if err := Reload(); err != nil {
+				logrus.WithError(err).WithField("CfgFile", viper.ConfigFileUsed()).Error("cannot reload changed config file")
+				return
+			}
+			logrus.WithField("CfgFile", viper.ConfigFileUsed()).Info("config file changed and reloaded")
+			// includes may have changed
+			watchDirs(watcher)
+		})
+	}
+	watchDirs(watcher)
+	go watchFiles(watcher, reload)
+	logrus.WithField("CfgFile", viper.ConfigFileUsed()).Debug("watching config file")
+}
+
+// watchDirs adds folders of the config file and files it depends on to the
+// watcher
+func watchDirs(watcher *fsnotify.Watcher) {
+	main, _ := filepath.Abs(viper.ConfigFileUsed())
+	dirs := []string{filepath.Dir(main)}
+	if t := LoadedTree(); t != nil {
+		dirs = append(dirs, t.watchedDirs(OverlayFiles(main))...)
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			logrus.WithError(err).WithField("dir", dir).Debug("cannot watch folder of config files")
+		}
+	}
+}
+
+// watchFiles calls reload when the config file, or files included by or
+// overlaying it, change
+func watchFiles(watcher *fsnotify.Watcher, reload func()) {
+	for {
+		select {
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			fn := filepath.Clean(e.Name)
+			main, _ := filepath.Abs(viper.ConfigFileUsed())
+			if fn == main {
+				logrus.WithField("file", fn).Debug("config file changed")
+				reload()
+			} else if t := LoadedTree(); t != nil && t.dependsOn(fn, OverlayFiles(main)) {
+				logrus.WithField("file", fn).Debug("included config file changed")
+				reload()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.WithError(err).Debug("error watching config files")
+		}
+	}
+}
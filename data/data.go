@@ -6,3 +6,6 @@ import "net/http"
 
 // ExampleAssets include sample config etc
 var ExampleAssets http.FileSystem = http.Dir("examples")
+
+// WebUIAssets include static files of the web UI served by the API
+var WebUIAssets http.FileSystem = http.Dir("webui")
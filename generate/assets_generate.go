@@ -24,4 +24,14 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
+
+	err = vfsgen.Generate(addPathPrefix(data.WebUIAssets), vfsgen.Options{
+		PackageName:  "data",
+		BuildTags:    "!dev",
+		VariableName: "WebUIAssets",
+		Filename:     "../data/webui_vfsdata.go",
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
 }
@@ -0,0 +1,152 @@
+package history
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/genzj/goTApaper/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Health keeps recent download results of a channel
+type Health struct {
+	Name                string
+	ConsecutiveFailures int
+	LastError           string
+	LastFailure         time.Time
+	LastSuccess         time.Time
+	CoolDownUntil       time.Time
+}
+
+// CoolingDown tells whether the channel should be skipped at the moment
+func (h Health) CoolingDown(now time.Time) bool {
+	return now.Before(h.CoolDownUntil)
+}
+
+// CoolDownPolicy decides how long a failing channel is skipped
+type CoolDownPolicy struct {
+	// Threshold is the number of consecutive failures tolerated before
+	// cooling down
+	Threshold int `mapstructure:"threshold"`
+	// Base is the seconds of the first cool-down, doubled on each further
+	// failure
+	Base float64 `mapstructure:"base-cool-down"`
+	// Max caps the seconds of a cool-down
+	Max float64 `mapstructure:"max-cool-down"`
+}
+
+// coolDown returns how long to skip a channel after the given number of
+// consecutive failures
+func (p CoolDownPolicy) coolDown(failures int) time.Duration {
+	if p.Base <= 0 || failures < p.Threshold {
+		return 0
+	}
+	seconds := p.Base
+	for i := p.Threshold; i < failures && (p.Max <= 0 || seconds < p.Max); i++ {
+		seconds *= 2
+	}
+	if p.Max > 0 && seconds > p.Max {
+		seconds = p.Max
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// JSONHealthManager keeps channel health in a JSON file next to history
+type JSONHealthManager struct {
+	l sync.Mutex
+}
+
+func (m *JSONHealthManager) load() (map[string]Health, error) {
+	fn := config.GetHealthFileName()
+	all := make(map[string]Health)
+	file, err := os.ReadFile(fn)
+	if err != nil && os.IsNotExist(err) {
+		return all, nil
+	} else if err != nil {
+		logrus.Errorf("error on loading health file: %s", err)
+		return nil, err
+	}
+	if err := json.Unmarshal(file, &all); err != nil {
+		logrus.WithField("error", err).Warnln("corrupted health file")
+		// ignore error, next save will correct it
+		return make(map[string]Health), nil
+	}
+	return all, nil
+}
+
+func (m *JSONHealthManager) save(all map[string]Health) error {
+	fn := config.GetHealthFileName()
+	bs, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		logrus.WithField("error", err).Errorln("cannot save health file")
+		return err
+	}
+	return os.WriteFile(fn, bs, os.FileMode(0644))
+}
+
+// LoadAll returns health of all channels ever tried
+func (m *JSONHealthManager) LoadAll() (map[string]Health, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.load()
+}
+
+// Load health of a channel
+func (m *JSONHealthManager) Load(name string) (Health, error) {
+	all, err := m.LoadAll()
+	if err != nil {
+		return Health{Name: name}, err
+	}
+	if h, ok := all[name]; ok {
+		return h, nil
+	}
+	return Health{Name: name}, nil
+}
+
+func (m *JSONHealthManager) update(name string, fn func(h *Health)) (Health, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	all, err := m.load()
+	if err != nil {
+		return Health{Name: name}, err
+	}
+	h, ok := all[name]
+	if !ok {
+		h = Health{Name: name}
+	}
+	fn(&h)
+	all[name] = h
+	return h, m.save(all)
+}
+
+// RecordSuccess resets failures of a channel
+func (m *JSONHealthManager) RecordSuccess(name string) (Health, error) {
+	return m.update(name, func(h *Health) {
+		h.ConsecutiveFailures = 0
+		h.LastSuccess = time.Now()
+		h.CoolDownUntil = time.Time{}
+	})
+}
+
+// RecordFailure counts a failure of a channel and starts a cool-down if it
+// keeps failing
+func (m *JSONHealthManager) RecordFailure(name string, cause error, policy CoolDownPolicy) (Health, error) {
+	return m.update(name, func(h *Health) {
+		h.ConsecutiveFailures++
+		h.LastFailure = time.Now()
+		if cause != nil {
+			h.LastError = cause.Error()
+		}
+		if d := policy.coolDown(h.ConsecutiveFailures); d > 0 {
+			h.CoolDownUntil = h.LastFailure.Add(d)
+			logrus.WithField("channel", name).WithField("failures", h.ConsecutiveFailures).WithField(
+				"until", h.CoolDownUntil,
+			).Warn("channel keeps failing, cooling down")
+		}
+	})
+}
+
+// JSONHealthManagerSingleton is the default instance
+var JSONHealthManagerSingleton = &JSONHealthManager{}
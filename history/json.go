@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/genzj/goTApaper/config"
 
@@ -13,15 +15,30 @@ import (
 type skeleton struct {
 	Meta    map[string]string
 	History map[string]History
+	// Wallpapers applied to the desktop, the latest at the end
+	Wallpapers []Record `json:",omitempty"`
+	// Banned maps URLs of unwanted pictures to when they were banned
+	Banned map[string]time.Time `json:",omitempty"`
 }
 
 // JSONHistoryManager keeps downloading records in JSON file
 type JSONHistoryManager struct {
 	skeleton skeleton
+	readOnly bool
+	// l guards the skeleton, since channels may be downloaded in parallel
+	l *sync.Mutex
+}
+
+// SetReadOnly prevents the manager from writing to the disk file, Save
+// becomes a no-op
+func (m *JSONHistoryManager) SetReadOnly(readOnly bool) {
+	m.readOnly = readOnly
 }
 
 // Load a JSON history file from disk
 func (m JSONHistoryManager) Load(name string) (*History, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
 	fn := config.GetHistoryFileName()
 	file, e := ioutil.ReadFile(fn)
 
@@ -32,14 +49,16 @@ func (m JSONHistoryManager) Load(name string) (*History, error) {
 		return nil, e
 	}
 
-	if e := json.Unmarshal(file, &m.skeleton); e != nil {
+	// decode into a new skeleton, as maps of m are shared with the manager
+	all := skeleton{}
+	if e := json.Unmarshal(file, &all); e != nil {
 		logrus.WithField("error", e).Warnln("corrupted history file")
 		// ignore error, maybe corrupted file, expect next save
 		// may correct it.
 		return NewHistory(name), nil
 	}
 
-	h, ok := m.skeleton.History[name]
+	h, ok := all.History[name]
 	if ok {
 		return &h, nil
 	}
@@ -48,15 +67,72 @@ func (m JSONHistoryManager) Load(name string) (*History, error) {
 
 // Save to disk file
 func (m *JSONHistoryManager) Save(h *History) error {
-	fn := config.GetHistoryFileName()
+	return m.update("history "+h.Name, func(s *skeleton) {
+		s.History[h.Name] = *h
+	})
+}
+
+// reload replaces the skeleton with the whole history file, so that entries
+// removed by other processes don't come back
+func (m *JSONHistoryManager) reload() error {
+	file, err := ioutil.ReadFile(config.GetHistoryFileName())
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("error on loading history file: %s", err)
+		return err
+	}
+	m.skeleton = skeleton{}
+	if err == nil {
+		if err := json.Unmarshal(file, &m.skeleton); err != nil {
+			logrus.WithField("error", err).Warnln("corrupted history file")
+		}
+	}
+	if m.skeleton.Meta == nil {
+		m.skeleton.Meta = make(map[string]string)
+	}
+	if m.skeleton.History == nil {
+		m.skeleton.History = make(map[string]History)
+	}
+	if m.skeleton.Banned == nil {
+		m.skeleton.Banned = make(map[string]time.Time)
+	}
+	return nil
+}
+
+// LoadMeta returns a value saved by SaveMeta, or empty string if not found
+func (m *JSONHistoryManager) LoadMeta(key string) (string, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if err := m.reload(); err != nil {
+		return "", err
+	}
+	return m.skeleton.Meta[key], nil
+}
 
-	m.skeleton.History[h.Name] = *h
+// SaveMeta keeps a value along with history in the disk file
+func (m *JSONHistoryManager) SaveMeta(key, value string) error {
+	return m.update("meta "+key, func(s *skeleton) {
+		s.Meta[key] = value
+	})
+}
+
+// update applies the change to the latest history file and saves it
+func (m *JSONHistoryManager) update(what string, fn func(s *skeleton)) error {
+	if m.readOnly {
+		logrus.WithField("change", what).Debug("history is read-only, skip saving")
+		return nil
+	}
+	m.l.Lock()
+	defer m.l.Unlock()
+	if err := m.reload(); err != nil {
+		return err
+	}
+	fn(&m.skeleton)
 	bs, err := json.Marshal(m.skeleton)
 	if err != nil {
 		logrus.WithField("error", err).Errorln("cannot save history file")
 		return err
 	}
-	return ioutil.WriteFile(fn, bs, os.FileMode(0644))
+	return ioutil.WriteFile(config.GetHistoryFileName(), bs, os.FileMode(0644))
 }
 
 // JSONHistoryManagerSingleton is the default instance
@@ -65,4 +141,5 @@ var JSONHistoryManagerSingleton = &JSONHistoryManager{
 		Meta:    make(map[string]string),
 		History: make(map[string]History),
 	},
+	l: &sync.Mutex{},
 }
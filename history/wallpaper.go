@@ -0,0 +1,106 @@
+package history
+
+import (
+	"time"
+)
+
+// MaxRecords is the number of wallpapers kept in history
+const MaxRecords = 200
+
+// Record of a wallpaper applied to the desktop
+type Record struct {
+	URL        string
+	Channel    string
+	ChannelKey string
+	Title      string
+	Caption    string
+	Credit     string
+	Format     string
+	// File of the rendered picture, only valid for the latest record since
+	// the file is overwritten by the next wallpaper
+	File string
+	// ArchiveFile keeps a copy of the rendered picture, if archiving is
+	// enabled and the record is one of the latest few
+	ArchiveFile string `json:",omitempty"`
+	AppliedAt   time.Time
+	Favourite   bool
+	// FavouriteFile keeps a copy of the rendered picture of a favourite
+	FavouriteFile string `json:",omitempty"`
+}
+
+// AddRecord appends a wallpaper to history, oldest records are dropped if
+// there are more than MaxRecords
+func (m *JSONHistoryManager) AddRecord(r Record) error {
+	return m.update("record "+r.URL, func(s *skeleton) {
+		s.Wallpapers = append(s.Wallpapers, r)
+		if len(s.Wallpapers) > MaxRecords {
+			s.Wallpapers = s.Wallpapers[len(s.Wallpapers)-MaxRecords:]
+		}
+	})
+}
+
+// Records returns wallpapers applied, the latest first
+func (m *JSONHistoryManager) Records() ([]Record, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	ans := make([]Record, 0, len(m.skeleton.Wallpapers))
+	for i := len(m.skeleton.Wallpapers) - 1; i >= 0; i-- {
+		ans = append(ans, m.skeleton.Wallpapers[i])
+	}
+	return ans, nil
+}
+
+// Latest returns the wallpaper applied most recently, or nil if none
+func (m *JSONHistoryManager) Latest() (*Record, error) {
+	records, err := m.Records()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+// SetFavourite marks or unmarks all records of the URL. file is the copy of
+// the picture to keep, or empty to keep the current one
+func (m *JSONHistoryManager) SetFavourite(url string, favourite bool, file string) error {
+	return m.update("favourite "+url, func(s *skeleton) {
+		for i := range s.Wallpapers {
+			if s.Wallpapers[i].URL != url {
+				continue
+			}
+			s.Wallpapers[i].Favourite = favourite
+			if !favourite {
+				s.Wallpapers[i].FavouriteFile = ""
+			} else if file != "" {
+				s.Wallpapers[i].FavouriteFile = file
+			}
+		}
+	})
+}
+
+// Ban prevents the picture of the URL from being used again
+func (m *JSONHistoryManager) Ban(url string) error {
+	return m.update("ban "+url, func(s *skeleton) {
+		s.Banned[url] = time.Now()
+	})
+}
+
+// Unban allows the picture of the URL to be used again
+func (m *JSONHistoryManager) Unban(url string) error {
+	return m.update("unban "+url, func(s *skeleton) {
+		delete(s.Banned, url)
+	})
+}
+
+// IsBanned tells whether the picture of the URL has been banned
+func (m *JSONHistoryManager) IsBanned(url string) bool {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if err := m.reload(); err != nil {
+		return false
+	}
+	_, ok := m.skeleton.Banned[url]
+	return ok
+}
@@ -0,0 +1,299 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cacheEntrySuffix is the extension name of cache entry files
+	cacheEntrySuffix = ".json"
+	// maxCacheEntrySize limits the body size of a single cache entry, larger
+	// responses are passed through without being stored
+	maxCacheEntrySize = 4 << 20
+)
+
+// cacheEntry is a stored response along with its validators
+type cacheEntry struct {
+	URL          string
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	StoredAt     time.Time
+	ETag         string
+	LastModified string
+	MaxAge       int64
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return e.MaxAge > 0 && now.Before(e.StoredAt.Add(time.Duration(e.MaxAge)*time.Second))
+}
+
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// HTTPCache stores GET responses on disk and revalidates them with ETag and
+// Last-Modified. Images are never stored since they are only downloaded once
+// with the help of the history mechanism
+type HTTPCache struct {
+	dir             string
+	maxSize         int64
+	offlineFallback bool
+	l               sync.Mutex
+}
+
+// NewHTTPCache creates a cache storing entries in dir. Oldest entries are
+// removed once the total size exceeds maxSize bytes. Stale entries are served
+// on network failures if offlineFallback is set
+func NewHTTPCache(dir string, maxSize int64, offlineFallback bool) *HTTPCache {
+	return &HTTPCache{
+		dir:             dir,
+		maxSize:         maxSize,
+		offlineFallback: offlineFallback,
+	}
+}
+
+var httpCache *HTTPCache
+
+// SetHTTPCache enables the cache for the HTTP helpers, nil disables it
+func SetHTTPCache(cache *HTTPCache) {
+	httpCache = cache
+}
+
+func (c *HTTPCache) key(req *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.URL.String()))
+	// responses of API requests depend on the credential
+	h.Write([]byte("\n" + req.Header.Get("Authorization")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *HTTPCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key+cacheEntrySuffix)
+}
+
+func (c *HTTPCache) load(key string) *cacheEntry {
+	c.l.Lock()
+	defer c.l.Unlock()
+	bs, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(bs, entry); err != nil {
+		logrus.WithError(err).WithField("key", key).Warn("corrupted cache entry ignored")
+		return nil
+	}
+	return entry
+}
+
+func (c *HTTPCache) store(key string, entry *cacheEntry) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	l := logrus.WithField("url", entry.URL)
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		l.WithError(err).Warn("cannot marshal cache entry")
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		l.WithError(err).Warn("cannot create cache dir")
+		return
+	}
+	if err := os.WriteFile(c.entryPath(key), bs, 0644); err != nil {
+		l.WithError(err).Warn("cannot write cache entry")
+		return
+	}
+	l.WithField("size", len(bs)).Debug("response cached")
+	c.prune()
+}
+
+// prune removes the least recently stored entries until the cache fits into
+// its size cap. Caller must hold the lock
+func (c *HTTPCache) prune() {
+	if c.maxSize <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(c.dir, "*"+cacheEntrySuffix))
+	if err != nil {
+		return
+	}
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	var total int64
+	for _, path := range matches {
+		if stat, err := os.Stat(path); err == nil {
+			files = append(files, file{path, stat.Size(), stat.ModTime()})
+			total += stat.Size()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			logrus.WithError(err).Warnf("cannot remove cache entry %s", f.path)
+			continue
+		}
+		total -= f.size
+		logrus.Debugf("cache entry %s evicted", f.path)
+	}
+}
+
+// cacheControl parses max-age and no-store out of a Cache-Control header
+func cacheControl(header http.Header) (maxAge int64, noStore bool) {
+	noCache := false
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			noStore = true
+		case directive == "no-cache":
+			noCache = true
+		case strings.HasPrefix(directive, "max-age="):
+			if v, err := strconv.ParseInt(strings.TrimPrefix(directive, "max-age="), 10, 64); err == nil {
+				maxAge = v
+			}
+		}
+	}
+	if noCache {
+		// must revalidate before every use
+		maxAge = 0
+	}
+	return maxAge, noStore
+}
+
+// cacheableType tells if responses of the content type are metadata like
+// JSON, HTML or XML. Pictures and other binaries are never cached
+func cacheableType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	mediaType = strings.ToLower(mediaType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") ||
+		mediaType == "application/javascript"
+}
+
+// Do sends the request with the cache consulted first. send is used to
+// access the network
+func (c *HTTPCache) Do(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return send(req)
+	}
+
+	key := c.key(req)
+	l := logrus.WithField("url", MaskURL(req.URL))
+	entry := c.load(key)
+	if entry != nil && entry.fresh(time.Now()) {
+		l.Debug("fresh response served from cache")
+		return entry.response(req), nil
+	}
+
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := send(req)
+	if err != nil || resp.StatusCode >= 500 {
+		if entry != nil && c.offlineFallback && req.Context().Err() == nil {
+			l.WithError(err).Warn("network unavailable, serving stale response from cache")
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			return entry.response(req), nil
+		}
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		_ = resp.Body.Close()
+		l.Debug("cached response revalidated")
+		entry.StoredAt = time.Now()
+		if maxAge, _ := cacheControl(resp.Header); maxAge > 0 {
+			entry.MaxAge = maxAge
+		}
+		c.store(key, entry)
+		return entry.response(req), nil
+	}
+
+	maxAge, noStore := cacheControl(resp.Header)
+	if resp.StatusCode != http.StatusOK || noStore || resp.ContentLength > maxCacheEntrySize {
+		return resp, nil
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if isGenericContentType(contentType) {
+		// pictures are often served as octet-stream or without a type
+		contentType = sniffContentType(resp)
+	}
+	if !cacheableType(contentType) {
+		l.WithField("type", contentType).Debug("response not cached by its content type")
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCacheEntrySize+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxCacheEntrySize {
+		// too large to be cached, hand over the rest of the stream as is
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+
+	entry = &cacheEntry{
+		URL:          MaskURL(req.URL),
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header,
+		Body:         body,
+		StoredAt:     time.Now(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		MaxAge:       maxAge,
+	}
+	c.store(key, entry)
+	return entry.response(req), nil
+}
@@ -0,0 +1,197 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// dialTimeout limits the time spent on establishing a connection, the
+	// whole request is bounded by the context passed to helpers
+	dialTimeout = 30 * time.Second
+	// tlsHandshakeTimeout limits the time spent on TLS handshake
+	tlsHandshakeTimeout = 15 * time.Second
+	// responseHeaderTimeout limits the time waiting for response headers
+	responseHeaderTimeout = 30 * time.Second
+)
+
+// HTTPSettings configures how a channel accesses the network
+type HTTPSettings struct {
+	// Proxy can be "direct", "environment" or a URL of the proxy server
+	Proxy         string `mapstructure:"proxy"`
+	ProxyUsername string `mapstructure:"proxy-username"`
+	ProxyPassword string `mapstructure:"proxy-password"`
+	// Headers are added to every request unless the request sets them itself
+	Headers   map[string]string `mapstructure:"headers"`
+	UserAgent string            `mapstructure:"user-agent"`
+	// CABundle is a PEM file of certificates trusted besides system ones
+	CABundle           string `mapstructure:"ca-bundle"`
+	InsecureSkipVerify bool   `mapstructure:"insecure-skip-verify"`
+}
+
+// LoadHTTPSettings reads the global proxy and http settings and overrides them
+// with the http section of the given channel setting, if any
+func LoadHTTPSettings(setting *viper.Viper) HTTPSettings {
+	settings := HTTPSettings{
+		Proxy: Settings.GetString("proxy"),
+	}
+	if err := Settings.UnmarshalKey("http", &settings); err != nil {
+		logrus.WithError(err).Warn("cannot parse global http settings")
+	}
+	if setting != nil && setting.IsSet("http") {
+		if err := UnmarshalKey(setting, "http", &settings); err != nil {
+			logrus.WithError(err).Warn("cannot parse channel http settings")
+		}
+	}
+	return settings
+}
+
+// Client is an http client along with the settings applied to its requests
+type Client struct {
+	http     *http.Client
+	settings HTTPSettings
+}
+
+type clientKey struct{}
+
+// NewClient builds an http client according to the settings
+func NewClient(settings HTTPSettings) (*Client, error) {
+	transport, err := newTransport(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		http:     &http.Client{Transport: wrapTransport(transport)},
+		settings: settings,
+	}, nil
+}
+
+// WithClient returns a context carrying the client used by the HTTP helpers
+func WithClient(ctx context.Context, client *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// clientFrom returns the client in the context, or builds one with global
+// settings
+func clientFrom(ctx context.Context) (*Client, error) {
+	if client, ok := ctx.Value(clientKey{}).(*Client); ok && client != nil {
+		return client, nil
+	}
+	return NewClient(LoadHTTPSettings(nil))
+}
+
+// prepare adds configured headers to the request
+func (c *Client) prepare(req *http.Request) {
+	for key, value := range c.settings.Headers {
+		if req.Header.Get(key) == "" {
+			req.Header.Set(key, value)
+		}
+	}
+	if c.settings.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.settings.UserAgent)
+	}
+}
+
+func newTLSConfig(settings HTTPSettings) (*tls.Config, error) {
+	conf := &tls.Config{
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+	if settings.InsecureSkipVerify {
+		logrus.Warn("TLS certificate verification disabled")
+	}
+	if settings.CABundle == "" {
+		return conf, nil
+	}
+
+	fn, err := homedir.Expand(settings.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA bundle %s: %w", fn, err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		logrus.WithError(err).Debug("system cert pool unavailable, trust CA bundle only")
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in CA bundle %s", fn)
+	}
+	conf.RootCAs = pool
+	return conf, nil
+}
+
+// newTransport returns http transport with proper proxy and TLS settings
+func newTransport(settings HTTPSettings) (*http.Transport, error) {
+	var err error
+	var parsed *url.URL
+
+	var dialer proxy.Dialer
+
+	conf := settings.Proxy
+
+	switch strings.ToLower(conf) {
+	case "", "direct":
+		// leave for http transport
+	case "environment":
+		// leave for http transport
+	default:
+		if parsed, err = url.Parse(conf); err != nil {
+			dialer = nil
+		} else {
+			if settings.ProxyUsername != "" {
+				parsed.User = url.UserPassword(settings.ProxyUsername, settings.ProxyPassword)
+			}
+			if parsed.Scheme == "socks5" {
+				// use x/net/proxy to handle socks5 proxy
+				dialer, err = proxy.FromURL(parsed, proxy.Direct)
+			}
+		}
+	}
+	logrus.WithField("dialer", dialer).WithField("conf", conf).Debugf("dialer is of %T type", dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	httpTransport := &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: dialTimeout}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+	}
+	if dialer != nil {
+		// set socks5 as the dialer
+		if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+			httpTransport.DialContext = contextDialer.DialContext
+		} else {
+			httpTransport.DialContext = nil
+			httpTransport.Dial = dialer.Dial
+		}
+	} else if strings.ToLower(conf) == "environment" {
+		httpTransport.Proxy = http.ProxyFromEnvironment
+	} else if parsed != nil {
+		httpTransport.Proxy = http.ProxyURL(parsed)
+	}
+	return httpTransport, nil
+}
@@ -0,0 +1,172 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@reboot",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) should fail", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// 2026-10-19 is a Monday
+	cases := []struct {
+		expr, from, want string
+	}{
+		{"* * * * *", "2026-10-19 10:00", "2026-10-19 10:01"},
+		{"30 * * * *", "2026-10-19 10:30", "2026-10-19 11:30"},
+		{"5/10 * * * *", "2026-10-19 10:00", "2026-10-19 10:05"},
+		{"5/10 * * * *", "2026-10-19 10:05", "2026-10-19 10:15"},
+		{"5/10 * * * *", "2026-10-19 10:56", "2026-10-19 11:05"},
+		{"0-30/15 * * * *", "2026-10-19 10:31", "2026-10-19 11:00"},
+		{"0 8-10 * * *", "2026-10-19 10:00", "2026-10-20 08:00"},
+		{"0 9,17 * * *", "2026-10-19 09:00", "2026-10-19 17:00"},
+		{"0 9 * * 1-5", "2026-10-23 10:00", "2026-10-26 09:00"},
+		{"0 9 * * mon-fri", "2026-10-24 10:00", "2026-10-26 09:00"},
+		{"0 0 1 jan *", "2026-10-19 10:00", "2027-01-01 00:00"},
+		// 7 and 0 are both Sunday
+		{"0 0 * * 7", "2026-10-19 10:00", "2026-10-25 00:00"},
+		{"0 0 * * 0", "2026-10-19 10:00", "2026-10-25 00:00"},
+		{"0 0 * * sun", "2026-10-19 10:00", "2026-10-25 00:00"},
+		{"0 0 * * 5-7", "2026-10-19 10:00", "2026-10-23 00:00"},
+		// with both restricted, either the day of month or of week is due
+		{"0 0 1 * 1", "2026-10-20 00:00", "2026-10-26 00:00"},
+		{"0 0 1 * 1", "2026-10-27 00:00", "2026-11-01 00:00"},
+		// */n doesn't restrict the day, so both must match
+		{"0 0 */2 * 1", "2026-10-19 00:00", "2026-11-09 00:00"},
+		{"0 0 13 * */2", "2026-10-19 00:00", "2026-12-13 00:00"},
+		{"@hourly", "2026-10-19 10:15", "2026-10-19 11:00"},
+		{"@daily", "2026-10-19 10:15", "2026-10-20 00:00"},
+		{"@weekly", "2026-10-19 10:15", "2026-10-25 00:00"},
+		{"@monthly", "2026-10-19 10:15", "2026-11-01 00:00"},
+		{"0 0 29 2 *", "2026-10-19 10:15", "2028-02-29 00:00"},
+	}
+	for _, c := range cases {
+		s, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", c.expr, err)
+			continue
+		}
+		if got := s.Next(at(c.from)); !got.Equal(at(c.want)) {
+			t.Errorf("%q after %s: got %s, want %s", c.expr, c.from, got.Format("2006-01-02 15:04"), c.want)
+		}
+	}
+}
+
+func TestCronNextImpossible(t *testing.T) {
+	from := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4 *", "0 0 31 jun,sep,nov *"} {
+		s, err := ParseCron(expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.IsZero() {
+			t.Errorf("%q should never be due, got %s", expr, got)
+		}
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+
+	// in New York, clocks go from 02:00 to 03:00 on 2026-03-08 and from
+	// 02:00 back to 01:00 on 2026-11-01. In Santiago, midnight of 2026-09-06
+	// is skipped
+	cases := []struct {
+		name, expr string
+		from, want time.Time
+	}{
+		{
+			"skipped hour",
+			"30 2 * * *",
+			time.Date(2026, 3, 7, 23, 0, 0, 0, ny),
+			time.Date(2026, 3, 9, 2, 30, 0, 0, ny),
+		},
+		{
+			"after spring forward",
+			"0 3 * * *",
+			time.Date(2026, 3, 8, 0, 0, 0, 0, ny),
+			time.Date(2026, 3, 8, 3, 0, 0, 0, ny),
+		},
+		{
+			"daily across spring forward",
+			"0 0 * * *",
+			time.Date(2026, 3, 7, 12, 0, 0, 0, ny),
+			time.Date(2026, 3, 8, 0, 0, 0, 0, ny),
+		},
+		{
+			"daily across fall back",
+			"0 12 * * *",
+			time.Date(2026, 10, 31, 12, 0, 0, 0, ny),
+			time.Date(2026, 11, 1, 12, 0, 0, 0, ny),
+		},
+		{
+			// an hour after 01:00 EDT comes 01:00 EST
+			"repeated hour",
+			"0 * * * *",
+			time.Date(2026, 11, 1, 5, 0, 0, 0, time.UTC).In(ny),
+			time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			"skipped midnight",
+			"0 0 * * *",
+			time.Date(2026, 9, 5, 12, 0, 0, 0, santiago),
+			time.Date(2026, 9, 7, 0, 0, 0, 0, santiago),
+		},
+		{
+			"daily across skipped midnight",
+			"0 12 * * *",
+			time.Date(2026, 9, 5, 12, 0, 0, 0, santiago),
+			time.Date(2026, 9, 6, 12, 0, 0, 0, santiago),
+		},
+	}
+	for _, c := range cases {
+		loc := c.from.Location()
+		s, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("%s: ParseCron(%q): %v", c.name, c.expr, err)
+			continue
+		}
+		got := s.Next(c.from)
+		if !got.Equal(c.want) {
+			t.Errorf("%s: %q after %s: got %s, want %s", c.name, c.expr, c.from, got, c.want.In(loc))
+		}
+		if got.Location() != loc {
+			t.Errorf("%s: got %s, want it in %s", c.name, got, loc)
+		}
+	}
+}
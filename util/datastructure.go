@@ -1,6 +1,11 @@
 package util
 
-import "github.com/go-viper/mapstructure/v2"
+import (
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
 
 // MapToStruct converts a interface{} (usually unmarshalled from JSON) to a concrete type using mapstructure
 // Example usage:
@@ -16,6 +21,7 @@ import "github.com/go-viper/mapstructure/v2"
 func MapToStruct(input interface{}, result interface{}) error {
 	config := &mapstructure.DecoderConfig{
 		WeaklyTypedInput: true,
+		DecodeHook:       mapstructure.DecodeHookFuncType(resolveSecretHook),
 		Result:           result,
 	}
 
@@ -26,3 +32,28 @@ func MapToStruct(input interface{}, result interface{}) error {
 
 	return decoder.Decode(input)
 }
+
+// UnmarshalKey decodes the section key of v into result like
+// viper.UnmarshalKey, but also merges default values of nested keys which
+// viper ignores as soon as any key of the section is set in the config file
+func UnmarshalKey(v *viper.Viper, key string, result interface{}) error {
+	section := map[string]interface{}{}
+	prefix := key + "."
+	for _, k := range v.AllKeys() {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		node := section
+		path := strings.Split(strings.TrimPrefix(k, prefix), ".")
+		for _, p := range path[:len(path)-1] {
+			child, ok := node[p].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[p] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = v.Get(k)
+	}
+	return MapToStruct(section, result)
+}
@@ -1,132 +1,156 @@
 package util
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"net/url"
 	"strings"
 
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
-
-	"golang.org/x/net/proxy"
 )
 
-// getHTTPClient returns http client with proper proxy settings
-func getHTTPClient() (*http.Client, error) {
-	var err error
-	var parsed *url.URL
-
-	var dialer proxy.Dialer
-
-	conf := viper.GetString("proxy")
-
-	switch strings.ToLower(conf) {
-	case "direct":
-		// leave for http transport
-	case "environment":
-		// leave for http transport
-	default:
-		if parsed, err = url.Parse(conf); err != nil {
-			dialer = nil
-		} else if parsed.Scheme == "socks5" {
-			// use x/net/proxy to handle socks5 proxy
-			dialer, err = proxy.FromURL(parsed, proxy.Direct)
-		}
-	}
-	logrus.WithField("dialer", dialer).WithField("conf", conf).Debugf("dialer is of %T type", dialer)
+// Head sends requests with HEAD method
+func Head(ctx context.Context, url string, followRedirection bool) (*http.Response, error) {
+	resp, err := head(ctx, url, followRedirection)
 	if err != nil {
-		return nil, err
-	}
-	httpTransport := &http.Transport{}
-	httpClient := &http.Client{Transport: httpTransport}
-	if dialer != nil {
-		// set socks5 as the dialer
-		httpTransport.Dial = dialer.Dial
-	} else if strings.ToLower(conf) == "environment" {
-		httpTransport.Proxy = http.ProxyFromEnvironment
-	} else if parsed != nil {
-		httpTransport.Proxy = http.ProxyURL(parsed)
+		logrus.Errorf("http HEAD encounter error %v", err)
 	}
-	return httpClient, err
+	return resp, err
+}
 
+// Probe sends a HEAD request without following redirection like Head, but
+// leaves failures to the caller, which expects them while offline
+func Probe(ctx context.Context, url string) (*http.Response, error) {
+	return head(ctx, url, false)
 }
 
-// Head sends requests with HEAD method
-func Head(url string, followRedirection bool) (*http.Response, error) {
-	httpClient, err := getHTTPClient()
+func head(ctx context.Context, url string, followRedirection bool) (*http.Response, error) {
+	client, err := clientFrom(ctx)
 	if err != nil {
 		logrus.Error("cannot initiate http client")
 		logrus.Fatal(err)
 		return nil, err
 	}
 
+	// copy the shared client before changing its redirection policy
+	httpClient := *client.http
 	if !followRedirection {
 		httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		}
 	}
-	resp, err := httpClient.Head(url)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
-		logrus.Errorf("http HEAD encounter error %v", err)
 		return nil, err
 	}
+	client.prepare(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, maskURLError(err, req.URL)
+	}
 	return resp, err
 }
 
 // IsReachableLink checks reachability of URL
-func IsReachableLink(url string) bool {
-	response, err := Head(url, false)
+func IsReachableLink(ctx context.Context, url string) bool {
+	response, err := Head(ctx, url, false)
 	if err != nil {
 		return false
 	}
+	_ = response.Body.Close()
 	logrus.Debugf("HEAD response %s", response.Status)
 	return (response.StatusCode / 100) == 2
 }
 
 // DoAndExpectType sends request to server and expects response with specified
-// content type
+// content type. The request should be created with a context, e.g. by
+// http.NewRequestWithContext, so that it can be cancelled
 func DoAndExpectType(req *http.Request, expected string) (*http.Response, error) {
-	client, err := getHTTPClient()
+	client, err := clientFrom(req.Context())
 	if err != nil {
 		logrus.Error("cannot initiate http client")
 		logrus.Fatal(err)
 		return nil, err
 	}
+	client.prepare(req)
 
-	resp, err := client.Do(req)
+	send := func(req *http.Request) (*http.Response, error) {
+		return doWithRetry(client.http, req)
+	}
+	var resp *http.Response
+	if httpCache != nil {
+		resp, err = httpCache.Do(req, send)
+	} else {
+		resp, err = send(req)
+	}
 	if err != nil {
 		return nil, err
 	}
 	logrus.WithFields(logrus.Fields{
-		"url":         req.URL,
+		"url":         MaskURL(req.URL),
 		"len":         resp.ContentLength,
 		"status-code": resp.StatusCode,
 		"status":      resp.Status,
 	}).Debug("server responded")
 
+	contentType := resp.Header.Get("Content-Type")
+	if isGenericContentType(contentType) {
+		contentType = sniffContentType(resp)
+		logrus.WithField("url", MaskURL(req.URL)).WithField("sniffed", contentType).Debug("content type sniffed")
+	}
+
 	if !strings.HasPrefix(
-		strings.ToLower(resp.Header.Get("Content-Type")),
+		strings.ToLower(contentType),
 		strings.ToLower(expected),
 	) {
+		_ = resp.Body.Close()
 		return nil, fmt.Errorf(
 			"Response not in type %s but %s",
 			expected,
-			resp.Header.Get("Content-Type"),
+			contentType,
 		)
 	}
 	return resp, nil
 }
 
+// isGenericContentType tells if the server doesn't know the actual type of
+// the content, e.g. a misconfigured CDN or object storage
+func isGenericContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch strings.ToLower(mediaType) {
+	case "", "application/octet-stream", "binary/octet-stream":
+		return true
+	}
+	return false
+}
+
+// sniffContentType detects the content type with the beginning of response
+// body. The body is rewound so that it can still be read as a whole
+func sniffContentType(resp *http.Response) string {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		logrus.WithError(err).Debug("cannot read response for content sniffing")
+	}
+	head = head[:n]
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	return http.DetectContentType(head)
+}
+
 // GetInType sends a GET request and expects a response with certain content type
-func GetInType(url, expected string) (*http.Response, error) {
-	logrus.Debugf("get %s", url)
-	req, err := http.NewRequest("GET", url, nil)
+func GetInType(ctx context.Context, url, expected string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	logrus.Debugf("get %s", MaskURL(req.URL))
 	resp, err := DoAndExpectType(req, expected)
 	if err != nil {
 		return nil, err
@@ -135,8 +159,8 @@ func GetInType(url, expected string) (*http.Response, error) {
 }
 
 // Get a response from specified URL
-func Get(url string) (*http.Response, error) {
-	return GetInType(url, "")
+func Get(ctx context.Context, url string) (*http.Response, error) {
+	return GetInType(ctx, url, "")
 }
 
 // DoAndReadJSON sends request and parse its JSON response
@@ -163,8 +187,8 @@ func DoAndReadJSON(req *http.Request, obj interface{}) error {
 }
 
 // ReadJSON send a GET request to URL and parse its JSON response
-func ReadJSON(url string, obj interface{}) error {
-	req, err := http.NewRequest("GET", url, nil)
+func ReadJSON(ctx context.Context, url string, obj interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return err
 	}
@@ -178,8 +202,11 @@ type Extractor func(io.Reader) ([]byte, error)
 
 // ExtractJSON sends a GET request to the URL, extracts data using the provided Extractor function,
 // and unmarshals the extracted JSON data into obj
-func ExtractJSON(url string, obj interface{}, extract Extractor) error {
-	resp, err := Get(url)
+func ExtractJSON(ctx context.Context, url string, obj interface{}, extract Extractor) error {
+	resp, err := Get(ctx, url)
+	if err != nil {
+		return err
+	}
 
 	defer func() {
 		_ = resp.Body.Close()
@@ -192,4 +219,3 @@ func ExtractJSON(url string, obj interface{}, extract Extractor) error {
 
 	return json.Unmarshal(data, obj)
 }
-
@@ -2,6 +2,8 @@ package util
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"image/jpeg"
 	"io"
@@ -12,14 +14,11 @@ import (
 	"strings"
 
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 // Viewpoint returns the visible region of a image after being centered and
-// filling the desktop
-func Viewpoint(w0, h0 float64) (w, h float64) {
-	refWidth := viper.GetFloat64("reference-width")
-	refHeight := viper.GetFloat64("reference-height")
+// filling the desktop of the reference size
+func Viewpoint(w0, h0, refWidth, refHeight float64) (w, h float64) {
 	fillRatio := refWidth / refHeight
 
 	w1 := w0
@@ -55,48 +54,93 @@ func Viewpoint(w0, h0 float64) (w, h float64) {
 	return w1, h1
 }
 
-// DecodeFromResponse return picture in http response
-func DecodeFromResponse(resp *http.Response) (raw *bytes.Reader, img image.Image, format string, err error) {
-	defer func() {
-		_ = resp.Body.Close()
-	}()
+// ErrDownloadTooLarge is returned if a picture exceeds download.max-size
+var ErrDownloadTooLarge = errors.New("download exceeds the size limit")
 
-	bs, err := io.ReadAll(resp.Body)
+// ErrTooManyPixels is returned if a picture exceeds download.max-pixels
+var ErrTooManyPixels = errors.New("picture exceeds the pixel limit")
+
+// readLimited reads the whole reader but fails once more than limit bytes
+// are read. A non-positive limit means no limit
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	bs, err := io.ReadAll(io.LimitReader(r, limit+1))
 	if err != nil {
-		return nil, nil, "", err
+		return nil, err
+	}
+	if int64(len(bs)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrDownloadTooLarge, limit)
 	}
+	return bs, nil
+}
 
+// decodeBytes checks dimensions of the picture before decoding it entirely,
+// to avoid exhausting memory on a huge picture
+func decodeBytes(bs []byte) (raw *bytes.Reader, img image.Image, format string, err error) {
 	raw = bytes.NewReader(bs)
-	logrus.WithField("filesize", raw.Len()).Info("wallpaper downloaded")
 
-	reader2 := bytes.NewReader(bs)
-	img, format, err = image.Decode(reader2)
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(bs))
+	if err != nil {
+		return raw, nil, "", err
+	}
+	l := logrus.WithField("width", cfg.Width).WithField("height", cfg.Height).WithField("format", format)
+	if maxPixels := Settings.GetInt64("download.max-pixels"); maxPixels > 0 &&
+		int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		l.WithField("max-pixels", maxPixels).Warn("picture too large to decode")
+		return raw, nil, format, fmt.Errorf("%w: %dx%d", ErrTooManyPixels, cfg.Width, cfg.Height)
+	}
+	l.Debug("picture dimensions checked")
+
+	img, format, err = image.Decode(bytes.NewReader(bs))
 	if err != nil {
 		return raw, nil, "", err
 	}
 	return raw, img, format, nil
 }
 
+// DecodeFromResponse return picture in http response
+func DecodeFromResponse(resp *http.Response) (raw *bytes.Reader, img image.Image, format string, err error) {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	maxSize := Settings.GetInt64("download.max-size")
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		return nil, nil, "", fmt.Errorf(
+			"%w: server declares %d bytes, limit is %d", ErrDownloadTooLarge, resp.ContentLength, maxSize,
+		)
+	}
+
+	bs, err := readLimited(resp.Body, maxSize)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	logrus.WithField("filesize", len(bs)).Info("wallpaper downloaded")
+
+	return decodeBytes(bs)
+}
+
 // DecodeFromFile returns picture from a file path
 func DecodeFromFile(filepath string) (raw *bytes.Reader, img image.Image, format string, err error) {
 	if runtime.GOOS == "windows" {
 		filepath, _ = strings.CutPrefix(filepath, "/")
 	}
 
-	bs, err := os.ReadFile(filepath)
+	f, err := os.Open(filepath)
 	if err != nil {
 		return nil, nil, "", err
 	}
+	defer f.Close()
 
-	raw = bytes.NewReader(bs)
-	logrus.WithField("filesize", raw.Len()).WithField("filepath", filepath).Info("file loaded")
-
-	reader2 := bytes.NewReader(bs)
-	img, format, err = image.Decode(reader2)
+	bs, err := readLimited(f, Settings.GetInt64("download.max-size"))
 	if err != nil {
-		return raw, nil, "", err
+		return nil, nil, "", err
 	}
-	return raw, img, format, nil
+	logrus.WithField("filesize", len(bs)).WithField("filepath", filepath).Info("file loaded")
+
+	return decodeBytes(bs)
 }
 
 func SaveImageToJpeg(img image.Image, filepath string, quality int) error {
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned if the lock is held by another process
+var ErrLocked = errors.New("lock is held by another process")
+
+// InstanceLock is an exclusive lock of a file kept until the process exits
+// or Release is called. The file contains PID of the holder
+type InstanceLock struct {
+	file *os.File
+}
+
+// AcquireLock locks the file without waiting. An error wrapping ErrLocked is
+// returned if another process holds the lock
+func AcquireLock(path string) (*InstanceLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		if pid := LockHolder(path); pid > 0 {
+			return nil, fmt.Errorf("%w (pid %d)", ErrLocked, pid)
+		}
+		return nil, err
+	}
+	if err := file.Truncate(0); err == nil {
+		_, _ = file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	return &InstanceLock{file: file}, nil
+}
+
+// LockHolder returns PID written in the lock file, or 0 if unknown
+func LockHolder(path string) int {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(bs)))
+	return pid
+}
+
+// Release unlocks and closes the file
+func (l *InstanceLock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	_ = l.file.Truncate(0)
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
@@ -0,0 +1,187 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ReplayModeOff sends requests to the network as usual
+	ReplayModeOff = ""
+	// ReplayModeRecord sends requests to the network and saves the exchanges
+	ReplayModeRecord = "record"
+	// ReplayModeReplay serves requests from saved exchanges only
+	ReplayModeReplay = "replay"
+)
+
+// sensitiveHeaders are masked in recorded requests so that a capture can be
+// attached to bug reports, along with secret-like ones such as X-Api-Key
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+var unsafeFixtureChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// recordedRequest is the request part of a saved exchange, for reference only
+type recordedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+}
+
+// recordedResponse is the response part of a saved exchange
+type recordedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+type exchange struct {
+	Request  recordedRequest
+	Response recordedResponse
+}
+
+var replayMode, replayDir string
+
+// SetReplayMode makes all http clients record exchanges to, or replay them
+// from, the dir. Clients created before the call are not affected
+func SetReplayMode(mode, dir string) {
+	replayMode, replayDir = mode, dir
+	if mode != ReplayModeOff {
+		logrus.WithField("mode", mode).WithField("dir", dir).Info("http record/replay enabled")
+	}
+}
+
+func isSensitiveHeader(key string) bool {
+	for _, sensitive := range sensitiveHeaders {
+		if strings.EqualFold(key, sensitive) {
+			return true
+		}
+	}
+	return IsSecretKey(key)
+}
+
+// FixtureName returns the file name of the exchange of a request. Requests
+// are matched on method and URL, which should be masked by MaskURL
+func FixtureName(method, url string) string {
+	sum := sha256.Sum256([]byte(method + " " + url))
+	readable := strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	if i := strings.IndexAny(readable, "?#"); i >= 0 {
+		readable = readable[:i]
+	}
+	readable = strings.Trim(unsafeFixtureChars.ReplaceAllString(readable, "_"), "_")
+	if len(readable) > 80 {
+		readable = readable[:80]
+	}
+	return fmt.Sprintf("%s-%s-%s.json", strings.ToLower(method), readable, hex.EncodeToString(sum[:])[:12])
+}
+
+// wrapTransport applies the current record/replay mode to a transport
+func wrapTransport(next http.RoundTripper) http.RoundTripper {
+	switch replayMode {
+	case ReplayModeRecord:
+		return &recordingTransport{dir: replayDir, next: next}
+	case ReplayModeReplay:
+		return &replayingTransport{dir: replayDir}
+	default:
+		return next
+	}
+}
+
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := req.Header.Clone()
+	for key := range header {
+		if isSensitiveHeader(key) {
+			header.Set(key, "REDACTED")
+		}
+	}
+	maskedURL := MaskURL(req.URL)
+	ex := exchange{
+		Request: recordedRequest{
+			Method: req.Method,
+			URL:    maskedURL,
+			Header: header,
+		},
+		Response: recordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       body,
+		},
+	}
+
+	fn := filepath.Join(t.dir, FixtureName(req.Method, maskedURL))
+	l := logrus.WithField("url", maskedURL).WithField("fixture", fn)
+	bs, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		l.WithError(err).Warn("cannot marshal http exchange")
+		return resp, nil
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		l.WithError(err).Warn("cannot create record dir")
+		return resp, nil
+	}
+	if err := os.WriteFile(fn, bs, 0644); err != nil {
+		l.WithError(err).Warn("cannot record http exchange")
+		return resp, nil
+	}
+	l.Debug("http exchange recorded")
+	return resp, nil
+}
+
+type replayingTransport struct {
+	dir string
+}
+
+func (t *replayingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	maskedURL := MaskURL(req.URL)
+	fn := filepath.Join(t.dir, FixtureName(req.Method, maskedURL))
+	bs, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("no recorded response for %s %s: %w", req.Method, maskedURL, err)
+	}
+	ex := exchange{}
+	if err := json.Unmarshal(bs, &ex); err != nil {
+		return nil, fmt.Errorf("corrupted fixture %s: %w", fn, err)
+	}
+	logrus.WithField("url", maskedURL).WithField("fixture", fn).Debug("http exchange replayed")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", ex.Response.StatusCode, http.StatusText(ex.Response.StatusCode)),
+		StatusCode:    ex.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        ex.Response.Header,
+		Body:          io.NopCloser(bytes.NewReader(ex.Response.Body)),
+		ContentLength: int64(len(ex.Response.Body)),
+		Request:       req,
+	}, nil
+}
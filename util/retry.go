@@ -0,0 +1,182 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// RetryPolicy controls how failed HTTP requests are retried
+type RetryPolicy struct {
+	// Attempts is the total number of tries, including the first one
+	Attempts int `mapstructure:"attempts"`
+	// InitialDelay is the seconds to wait before the first retry
+	InitialDelay float64 `mapstructure:"initial-delay"`
+	// MaxDelay caps the seconds to wait between two tries
+	MaxDelay float64 `mapstructure:"max-delay"`
+	// Multiplier grows the delay after each retry
+	Multiplier float64 `mapstructure:"multiplier"`
+	// Jitter randomizes each delay by up to this fraction of it
+	Jitter float64 `mapstructure:"jitter"`
+}
+
+type retryPolicyKey struct{}
+
+// LoadRetryPolicy reads the global retry settings and overrides them with
+// the retry section of the given channel setting, if any
+func LoadRetryPolicy(setting *viper.Viper) RetryPolicy {
+	policy := RetryPolicy{}
+	if err := Settings.UnmarshalKey("retry", &policy); err != nil {
+		logrus.WithError(err).Warn("cannot parse global retry settings")
+	}
+	if setting != nil && setting.IsSet("retry") {
+		if err := UnmarshalKey(setting, "retry", &policy); err != nil {
+			logrus.WithError(err).Warn("cannot parse channel retry settings")
+		}
+	}
+	return policy
+}
+
+// WithRetryPolicy returns a context carrying the retry policy used by
+// DoAndExpectType
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// retryPolicyFrom returns the policy in the context, or the global one
+func retryPolicyFrom(ctx context.Context) RetryPolicy {
+	if policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return policy
+	}
+	return LoadRetryPolicy(nil)
+}
+
+// delay returns the backoff before the n-th retry (starting from 1)
+func (p RetryPolicy) delay(n int) time.Duration {
+	seconds := p.InitialDelay * math.Pow(math.Max(p.Multiplier, 1), float64(n-1))
+	if p.MaxDelay > 0 {
+		seconds = math.Min(seconds, p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		seconds += seconds * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(math.Max(seconds, 0) * float64(time.Second))
+}
+
+// isRetryableStatus tells whether a response status is worth another try
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isRetryableError tells whether a transport error is worth another try
+func isRetryableError(ctx context.Context, err error) bool {
+	// cancelled or timed out by caller, retrying doesn't help
+	return ctx.Err() == nil && !errors.Is(err, context.Canceled)
+}
+
+// parseRetryAfter understands both delay-seconds and HTTP-date forms of the
+// Retry-After header
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// doWithRetry sends the request with client, retrying transient failures
+// according to the policy carried by the request context
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	policy := retryPolicyFrom(ctx)
+	attempts := policy.Attempts
+	if attempts < 1 || (req.Body != nil && req.GetBody == nil) {
+		// a consumed body cannot be sent again
+		attempts = 1
+	}
+
+	for n := 1; ; n++ {
+		attempt := req
+		if n > 1 {
+			attempt = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attempt.Body = body
+			}
+		}
+
+		resp, err := client.Do(attempt)
+		err = maskURLError(err, req.URL)
+		l := logrus.WithField("url", MaskURL(req.URL)).WithField("attempt", n).WithField("attempts", attempts)
+		if n >= attempts {
+			return resp, err
+		}
+
+		wait := policy.delay(n)
+		if err != nil {
+			if !isRetryableError(ctx, err) {
+				return nil, err
+			}
+			l.WithError(err).Warn("request failed, retry later")
+		} else if isRetryableStatus(resp.StatusCode) {
+			if after, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				wait = after
+			}
+			_ = resp.Body.Close()
+			l.WithField("status", resp.Status).Warn("server responded with a transient error, retry later")
+		} else {
+			return resp, nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			l.WithField("wait", wait).Warn("retry would exceed the deadline, give up")
+			if err == nil {
+				err = errors.New("retry delay exceeds deadline after " + resp.Status)
+			}
+			return nil, err
+		}
+		l.WithField("wait", wait).Debug("waiting before retry")
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "go.yaml.in/yaml/v3"
+)
+
+// Schema is a JSON Schema in decoded form. ValidateYAML supports keywords
+// type, enum, const, properties, patternProperties, additionalProperties,
+// required, minProperties, maxProperties, items, minItems, minimum, maximum,
+// exclusiveMinimum, pattern, anyOf, allOf, if, then and else. Others like
+// description are ignored
+type Schema = map[string]interface{}
+
+// SchemaError is a violation of the schema found in a YAML document
+type SchemaError struct {
+	// Path of the value like channels.bing.strategy or watermark[0].font
+	Path    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e SchemaError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+// ValidateYAML checks all documents in the YAML data against the schema.
+// Aliases and merge keys are resolved as YAML loaders do. The error is only
+// returned if the data is not valid YAML
+func ValidateYAML(data []byte, schema Schema) ([]SchemaError, error) {
+	var errs []SchemaError
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := &yaml.Node{}
+		if err := decoder.Decode(doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		v := &schemaValidator{}
+		v.validate(resolveNode(doc), schema, "")
+		errs = append(errs, v.errs...)
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+	return errs, nil
+}
+
+type schemaValidator struct {
+	errs []SchemaError
+}
+
+func (v *schemaValidator) fail(node *yaml.Node, path, format string, args ...interface{}) {
+	v.errs = append(v.errs, SchemaError{
+		Path:    path,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// resolveNode follows documents and aliases to the actual value
+func resolveNode(node *yaml.Node) *yaml.Node {
+	for node != nil {
+		switch {
+		case node.Kind == yaml.DocumentNode && len(node.Content) > 0:
+			node = node.Content[0]
+		case node.Kind == yaml.AliasNode:
+			node = node.Alias
+		default:
+			return node
+		}
+	}
+	return node
+}
+
+type mappingEntry struct {
+	key, value *yaml.Node
+}
+
+// mappingEntries lists entries of the mapping, including merged ones not
+// overridden by explicit keys
+func mappingEntries(node *yaml.Node) []mappingEntry {
+	var explicit, merged []mappingEntry
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolveNode(node.Content[i+1])
+		if key.Tag != "!!merge" {
+			explicit = append(explicit, mappingEntry{key, value})
+			continue
+		}
+		sources := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			sources = sources[:0]
+			for _, item := range value.Content {
+				sources = append(sources, resolveNode(item))
+			}
+		}
+		for _, source := range sources {
+			if source.Kind == yaml.MappingNode {
+				merged = append(merged, mappingEntries(source)...)
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(explicit))
+	for _, e := range explicit {
+		seen[e.key.Value] = true
+	}
+	entries := explicit
+	for _, e := range merged {
+		if !seen[e.key.Value] {
+			seen[e.key.Value] = true
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch node.ShortTag() {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func typeMatches(actual, expected string) bool {
+	return actual == expected || (expected == "number" && actual == "integer")
+}
+
+// schemaList accepts lists built in Go or decoded from JSON
+func schemaList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case []string:
+		ans := make([]interface{}, 0, len(v))
+		for _, s := range v {
+			ans = append(ans, s)
+		}
+		return ans
+	case []Schema:
+		ans := make([]interface{}, 0, len(v))
+		for _, s := range v {
+			ans = append(ans, s)
+		}
+		return ans
+	case nil:
+		return nil
+	default:
+		return []interface{}{v}
+	}
+}
+
+func schemaNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func scalarValue(node *yaml.Node) interface{} {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return node.Value
+	}
+	return value
+}
+
+func (v *schemaValidator) validate(node *yaml.Node, schema Schema, path string) {
+	if node == nil || schema == nil {
+		return
+	}
+	actual := nodeType(node)
+
+	if !acceptsType(schema, actual) {
+		names := []string{}
+		for _, t := range schemaList(schema["type"]) {
+			names = append(names, fmt.Sprint(t))
+		}
+		v.fail(node, path, "must be %s, got %s", strings.Join(names, " or "), actual)
+		return
+	}
+
+	if node.Kind == yaml.ScalarNode {
+		v.validateScalar(node, schema, path)
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		v.validateMapping(node, schema, path)
+	case yaml.SequenceNode:
+		v.validateSequence(node, schema, path)
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		if s, ok := sub.(Schema); ok {
+			v.validate(node, s, path)
+		}
+	}
+	if branches := schemaList(schema["anyOf"]); len(branches) > 0 {
+		v.validateAnyOf(node, branches, path)
+	}
+	if cond, ok := schema["if"].(Schema); ok {
+		probe := &schemaValidator{}
+		probe.validate(node, cond, path)
+		if then, ok := schema["then"].(Schema); ok && len(probe.errs) == 0 {
+			v.validate(node, then, path)
+		} else if otherwise, ok := schema["else"].(Schema); ok && len(probe.errs) > 0 {
+			v.validate(node, otherwise, path)
+		}
+	}
+}
+
+func (v *schemaValidator) validateScalar(node *yaml.Node, schema Schema, path string) {
+	value := scalarValue(node)
+	if c, ok := schema["const"]; ok && fmt.Sprint(c) != fmt.Sprint(value) {
+		v.fail(node, path, "must be %v", c)
+	}
+	if enum := schemaList(schema["enum"]); len(enum) > 0 {
+		names := make([]string, 0, len(enum))
+		found := false
+		for _, item := range enum {
+			names = append(names, fmt.Sprint(item))
+			found = found || fmt.Sprint(item) == fmt.Sprint(value)
+		}
+		if !found {
+			v.fail(node, path, "%q is not one of %s", node.Value, strings.Join(names, ", "))
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok && nodeType(node) == "string" {
+		if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(node.Value) {
+			v.fail(node, path, "%q does not match %s", node.Value, pattern)
+		}
+	}
+	if t := nodeType(node); t == "integer" || t == "number" {
+		n, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+		if err != nil {
+			return
+		}
+		if min, ok := schemaNumber(schema["minimum"]); ok && n < min {
+			v.fail(node, path, "must be at least %v", min)
+		}
+		if min, ok := schemaNumber(schema["exclusiveMinimum"]); ok && n <= min {
+			v.fail(node, path, "must be greater than %v", min)
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && n > max {
+			v.fail(node, path, "must be at most %v", max)
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (v *schemaValidator) validateMapping(node *yaml.Node, schema Schema, path string) {
+	entries := mappingEntries(node)
+	properties, _ := schema["properties"].(Schema)
+	patterns, _ := schema["patternProperties"].(Schema)
+
+	present := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		key := e.key.Value
+		present[key] = true
+		keyPath := joinPath(path, key)
+
+		matched := false
+		if sub, ok := properties[key].(Schema); ok {
+			v.validate(e.value, sub, keyPath)
+			matched = true
+		}
+		for pattern, sub := range patterns {
+			if re, err := regexp.Compile(pattern); err == nil && re.MatchString(key) {
+				if s, ok := sub.(Schema); ok {
+					v.validate(e.value, s, keyPath)
+				}
+				matched = true
+			}
+		}
+		if matched {
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				if suggestion := closestKey(key, properties); suggestion != "" {
+					v.fail(e.key, keyPath, "unknown key, did you mean %s?", suggestion)
+				} else {
+					v.fail(e.key, keyPath, "unknown key")
+				}
+			}
+		case Schema:
+			v.validate(e.value, additional, keyPath)
+		}
+	}
+
+	for _, key := range schemaList(schema["required"]) {
+		if name := fmt.Sprint(key); !present[name] {
+			v.fail(node, path, "missing required key %s", name)
+		}
+	}
+	if min, ok := schemaNumber(schema["minProperties"]); ok && float64(len(entries)) < min {
+		v.fail(node, path, "must have at least %v keys", min)
+	}
+	if max, ok := schemaNumber(schema["maxProperties"]); ok && float64(len(entries)) > max {
+		v.fail(node, path, "must have at most %v keys", max)
+	}
+}
+
+func (v *schemaValidator) validateSequence(node *yaml.Node, schema Schema, path string) {
+	if min, ok := schemaNumber(schema["minItems"]); ok && float64(len(node.Content)) < min {
+		v.fail(node, path, "must have at least %v items", min)
+	}
+	items, ok := schema["items"].(Schema)
+	if !ok {
+		return
+	}
+	for idx, item := range node.Content {
+		v.validate(resolveNode(item), items, fmt.Sprintf("%s[%d]", path, idx))
+	}
+}
+
+// acceptsType tells whether the schema allows the type of value at all
+func acceptsType(schema Schema, actual string) bool {
+	expected := schemaList(schema["type"])
+	for _, t := range expected {
+		if typeMatches(actual, fmt.Sprint(t)) {
+			return true
+		}
+	}
+	return len(expected) == 0
+}
+
+// validateAnyOf reports errors of the closest branch, i.e. the one accepting
+// the type of value with the fewest errors, if no branch matches
+func (v *schemaValidator) validateAnyOf(node *yaml.Node, branches []interface{}, path string) {
+	var best []SchemaError
+	var types []string
+	bestFound := false
+	for _, branch := range branches {
+		s, ok := branch.(Schema)
+		if !ok {
+			continue
+		}
+		if !acceptsType(s, nodeType(node)) {
+			for _, t := range schemaList(s["type"]) {
+				types = append(types, fmt.Sprint(t))
+			}
+			continue
+		}
+		probe := &schemaValidator{}
+		probe.validate(node, s, path)
+		if len(probe.errs) == 0 {
+			return
+		}
+		if !bestFound || len(probe.errs) < len(best) {
+			best, bestFound = probe.errs, true
+		}
+	}
+	if bestFound {
+		v.errs = append(v.errs, best...)
+	} else {
+		v.fail(node, path, "must be %s, got %s", strings.Join(types, " or "), nodeType(node))
+	}
+}
+
+// closestKey suggests a known key for a likely typo
+func closestKey(key string, properties Schema) string {
+	best, bestDistance := "", len(key)/3+1
+	for name := range properties {
+		if d := editDistance(key, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// LocateYAML returns the position of the value at the path in the first
+// document, where each item of path is a mapping key or a sequence index
+func LocateYAML(data []byte, path ...interface{}) (line, column int, ok bool) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return 0, 0, false
+	}
+	node := resolveNode(doc)
+	for _, item := range path {
+		var next *yaml.Node
+		switch key := item.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for _, e := range mappingEntries(node) {
+					if e.key.Value == key {
+						next = e.value
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key >= 0 && key < len(node.Content) {
+				next = resolveNode(node.Content[key])
+			}
+		}
+		if next == nil {
+			return node.Line, node.Column, false
+		}
+		node = next
+	}
+	return node.Line, node.Column, true
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// settingsLock guards the global viper instance, whose config is replaced
+// on reload while the daemon, the API and background jobs read it
+var settingsLock sync.RWMutex
+
+type settings struct{}
+
+// Settings reads the global viper instance with settingsLock held. Use it
+// instead of the package functions of viper wherever the config may be
+// reloaded at the same time
+var Settings settings
+
+// Update changes the global viper instance with settingsLock held, so that
+// readers see either the old or the new settings as a whole
+func (settings) Update(update func() error) error {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	return update()
+}
+
+func (settings) Get(key string) interface{} {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.Get(key)
+}
+
+func (settings) GetString(key string) string {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.GetString(key)
+}
+
+func (settings) GetInt(key string) int {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.GetInt(key)
+}
+
+func (settings) GetInt64(key string) int64 {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.GetInt64(key)
+}
+
+func (settings) GetUint32(key string) uint32 {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.GetUint32(key)
+}
+
+func (settings) GetFloat64(key string) float64 {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.GetFloat64(key)
+}
+
+func (settings) GetBool(key string) bool {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.GetBool(key)
+}
+
+func (settings) GetStringMap(key string) map[string]interface{} {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.GetStringMap(key)
+}
+
+func (settings) IsSet(key string) bool {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.IsSet(key)
+}
+
+func (settings) AllKeys() []string {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.AllKeys()
+}
+
+func (settings) AllSettings() map[string]interface{} {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.AllSettings()
+}
+
+// Sub returns the section of the key like viper.Sub. Maps in it are shared
+// with the global instance, which is fine since reloading replaces them
+// instead of changing them
+func (settings) Sub(key string) *viper.Viper {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.Sub(key)
+}
+
+// UnmarshalKey decodes the section of the key like UnmarshalKey
+func (settings) UnmarshalKey(key string, result interface{}) error {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return UnmarshalKey(viper.GetViper(), key, result)
+}
+
+// Unmarshal decodes all settings like viper.Unmarshal
+func (settings) Unmarshal(result interface{}) error {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return viper.Unmarshal(result)
+}
+
+// Copy returns a new viper instance holding all current settings, which can
+// be read without the lock
+func (settings) Copy() *viper.Viper {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	v := viper.New()
+	_ = v.MergeConfigMap(viper.AllSettings())
+	return v
+}
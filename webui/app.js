@@ -0,0 +1,416 @@
+// goTApaper web UI, talks to the API of the running daemon
+(function () {
+  'use strict';
+
+  var API = '/api/v1';
+  var state = { settings: null, channelTypes: [] };
+
+  // the token can be passed once in the page URL, e.g. /ui/?token=xxx
+  var params = new URLSearchParams(location.search);
+  if (params.get('token')) {
+    localStorage.setItem('goTApaper.token', params.get('token'));
+    history.replaceState(null, '', location.pathname);
+  }
+
+  function token() {
+    return localStorage.getItem('goTApaper.token') || '';
+  }
+
+  function $(selector, root) {
+    return (root || document).querySelector(selector);
+  }
+
+  function $$(selector, root) {
+    return Array.prototype.slice.call((root || document).querySelectorAll(selector));
+  }
+
+  function show(text, isError) {
+    var box = $('#message');
+    box.textContent = text;
+    box.className = isError ? 'error' : 'info';
+    box.hidden = !text;
+  }
+
+  function authHeaders() {
+    return token() ? { Authorization: 'Bearer ' + token() } : {};
+  }
+
+  // images are fetched with the token in the header, since the API doesn't
+  // accept it in the URL
+  function loadImage(img, url) {
+    fetch(url, { headers: authHeaders() }).then(function (resp) {
+      if (!resp.ok) {
+        throw new Error(resp.statusText);
+      }
+      return resp.blob();
+    }).then(function (blob) {
+      img.onload = function () {
+        URL.revokeObjectURL(img.src);
+      };
+      img.src = URL.createObjectURL(blob);
+    }).catch(function () {});
+  }
+
+  function request(method, path, body, raw) {
+    var options = { method: method, headers: authHeaders() };
+    // the API only accepts changes in JSON
+    if (method !== 'GET') {
+      options.headers['Content-Type'] = 'application/json';
+      options.body = JSON.stringify(body === undefined ? {} : body);
+    }
+    return fetch(API + path, options).then(function (resp) {
+      if (resp.status === 401) {
+        $('#login').hidden = false;
+      }
+      if (resp.ok && raw) {
+        return resp;
+      }
+      return resp.json().then(function (data) {
+        if (!resp.ok) {
+          var err = new Error(data.error || resp.statusText);
+          err.details = data.errors || [];
+          throw err;
+        }
+        return data;
+      });
+    });
+  }
+
+  function fail(err) {
+    var text = err.message;
+    if (err.details && err.details.length) {
+      text += ':\n' + err.details.join('\n');
+    }
+    show(text, true);
+  }
+
+  // getPath and setPath access nested values by dotted keys
+  function getPath(obj, key) {
+    return key.split('.').reduce(function (o, k) {
+      return o == null ? undefined : o[k];
+    }, obj);
+  }
+
+  function setPath(obj, key, value) {
+    var keys = key.split('.');
+    var last = keys.pop();
+    keys.forEach(function (k) {
+      if (typeof obj[k] !== 'object' || obj[k] === null) {
+        obj[k] = {};
+      }
+      obj = obj[k];
+    });
+    if (value === '' || value === null) {
+      delete obj[last];
+    } else {
+      obj[last] = value;
+    }
+  }
+
+  function clone(v) {
+    return JSON.parse(JSON.stringify(v === undefined ? null : v));
+  }
+
+  // tabs
+
+  function switchTab(name) {
+    $$('nav button').forEach(function (b) {
+      b.classList.toggle('active', b.dataset.tab === name);
+    });
+    $$('.tab').forEach(function (t) {
+      t.hidden = t.id !== name;
+    });
+    if (name === 'gallery') {
+      loadGallery();
+    } else if (name === 'watermark') {
+      updatePreview();
+    }
+  }
+
+  // status
+
+  function loadStatus() {
+    request('GET', '/status').then(function (status) {
+      var text = status.Paused ? 'paused' : 'running';
+      if (status.NextCycle) {
+        text += ', next refresh ' + new Date(status.NextCycle).toLocaleString();
+      }
+      $('#status').textContent = text;
+    }).catch(function () {
+      $('#status').textContent = '';
+    });
+  }
+
+  // gallery
+
+  function loadGallery() {
+    request('GET', '/gallery').then(function (items) {
+      var root = $('#gallery-items');
+      root.textContent = '';
+      items.forEach(function (item) {
+        var node = $('#gallery-item').content.cloneNode(true);
+        var figure = $('figure', node);
+        figure.classList.toggle('banned', item.Banned);
+        if (item.Image) {
+          loadImage($('img', node), item.Image);
+        }
+        $('img', node).alt = item.Title;
+        $('.title', node).textContent = item.Title || item.URL;
+        $('.meta', node).textContent = item.Channel + ' · ' + new Date(item.AppliedAt).toLocaleString();
+        var fav = $('.favourite', node);
+        fav.innerHTML = item.Favourite ? '&#9733;' : '&#9734;';
+        fav.addEventListener('click', function () {
+          request('POST', '/favourite', { url: item.URL, favourite: !item.Favourite })
+            .then(loadGallery).catch(fail);
+        });
+        var ban = $('.ban', node);
+        ban.textContent = item.Banned ? 'Unban' : 'Ban';
+        ban.addEventListener('click', function () {
+          request('POST', item.Banned ? '/unban' : '/ban', { url: item.URL })
+            .then(loadGallery).catch(fail);
+        });
+        root.appendChild(node);
+      });
+      if (!items.length) {
+        root.textContent = 'No wallpaper applied yet.';
+      }
+    }).catch(fail);
+  }
+
+  // settings
+
+  function loadSettings() {
+    return request('GET', '/settings').then(function (data) {
+      state.settings = data.settings;
+      state.channelTypes = data.channelTypes;
+      renderChannels();
+      renderWatermarks();
+    }).catch(fail);
+  }
+
+  function saveSettings(changes) {
+    show('');
+    return request('PUT', '/settings', changes).then(function (data) {
+      state.settings = data.settings;
+      renderChannels();
+      renderWatermarks();
+      show('Settings saved.');
+    }).catch(fail);
+  }
+
+  // channels
+
+  function activeRow(name, probability) {
+    var row = document.createElement('tr');
+    var select = document.createElement('select');
+    Object.keys(state.settings.channels || {}).sort().forEach(function (n) {
+      select.add(new Option(n, n, false, n === name));
+    });
+    var prob = document.createElement('input');
+    prob.type = 'number';
+    prob.min = 0;
+    prob.max = 1;
+    prob.step = 0.05;
+    prob.value = probability;
+    var remove = document.createElement('button');
+    remove.textContent = 'Remove';
+    remove.addEventListener('click', function () {
+      row.remove();
+    });
+    [select, prob, remove].forEach(function (el) {
+      var cell = document.createElement('td');
+      cell.appendChild(el);
+      row.appendChild(cell);
+    });
+    return row;
+  }
+
+  function channelDefinition(name, definition) {
+    var node = $('#channel-definition').content.cloneNode(true);
+    var fieldset = $('fieldset', node);
+    var options = clone(definition) || {};
+    var type = options.type || state.channelTypes[0];
+    delete options.type;
+    $('.name', node).value = name;
+    state.channelTypes.forEach(function (t) {
+      $('.type', node).add(new Option(t, t, false, t === type));
+    });
+    $('.options', node).value = JSON.stringify(options, null, 2);
+    $('.remove', node).addEventListener('click', function () {
+      fieldset.remove();
+    });
+    return node;
+  }
+
+  function renderChannels() {
+    var settings = state.settings;
+    var body = $('#active-channels tbody');
+    body.textContent = '';
+    (settings['active-channels'] || []).forEach(function (item) {
+      if (typeof item === 'string') {
+        body.appendChild(activeRow(item, 1));
+      } else {
+        Object.keys(item).forEach(function (name) {
+          body.appendChild(activeRow(name, item[name]));
+        });
+      }
+    });
+    var root = $('#channel-definitions');
+    root.textContent = '';
+    Object.keys(settings.channels || {}).sort().forEach(function (name) {
+      root.appendChild(channelDefinition(name, settings.channels[name]));
+    });
+  }
+
+  function collectChannels() {
+    var channels = {};
+    $$('#channel-definitions fieldset').forEach(function (fieldset) {
+      var name = $('.name', fieldset).value.trim();
+      var options;
+      try {
+        options = JSON.parse($('.options', fieldset).value || '{}');
+      } catch (e) {
+        throw new Error('options of channel ' + name + ' are not valid JSON: ' + e.message);
+      }
+      options.type = $('.type', fieldset).value;
+      channels[name] = options;
+    });
+    var active = $$('#active-channels tbody tr').map(function (row) {
+      var item = {};
+      item[$('select', row).value] = parseFloat($('input', row).value);
+      return item;
+    });
+    return { channels: channels, 'active-channels': active };
+  }
+
+  // watermark
+
+  function renderWatermarks() {
+    var root = $('#watermark-items');
+    root.textContent = '';
+    (state.settings.watermark || []).forEach(function (setting) {
+      root.appendChild(watermarkItem(setting));
+    });
+    updatePreview();
+  }
+
+  function watermarkItem(setting) {
+    var node = $('#watermark-item').content.cloneNode(true);
+    var fieldset = $('fieldset', node);
+    // keep settings not editable in the form
+    fieldset.setting = clone(setting) || {};
+    $$('[data-key]', node).forEach(function (input) {
+      var value = getPath(fieldset.setting, input.dataset.key);
+      input.value = value === undefined ? '' : value;
+      input.addEventListener('input', schedulePreview);
+    });
+    $('.remove', node).addEventListener('click', function () {
+      fieldset.remove();
+      schedulePreview();
+    });
+    return node;
+  }
+
+  function collectWatermarks() {
+    return $$('#watermark-items fieldset').map(function (fieldset) {
+      var setting = clone(fieldset.setting);
+      $$('[data-key]', fieldset).forEach(function (input) {
+        var value = input.value;
+        if (input.type === 'number') {
+          value = value === '' ? null : parseFloat(value);
+        }
+        setPath(setting, input.dataset.key, value);
+      });
+      return setting;
+    });
+  }
+
+  var previewTimer = null;
+  var previewURL = null;
+
+  function schedulePreview() {
+    clearTimeout(previewTimer);
+    previewTimer = setTimeout(updatePreview, 400);
+  }
+
+  function updatePreview() {
+    if (!state.settings || $('#watermark').hidden) {
+      return;
+    }
+    request('POST', '/preview', { watermark: collectWatermarks() }, true)
+      .then(function (resp) {
+        return resp.blob();
+      })
+      .then(function (blob) {
+        if (previewURL) {
+          URL.revokeObjectURL(previewURL);
+        }
+        previewURL = URL.createObjectURL(blob);
+        $('#preview').src = previewURL;
+        show('');
+      })
+      .catch(fail);
+  }
+
+  // wiring
+
+  $$('nav button').forEach(function (b) {
+    b.addEventListener('click', function () {
+      switchTab(b.dataset.tab);
+    });
+  });
+
+  $('#login-button').addEventListener('click', function () {
+    localStorage.setItem('goTApaper.token', $('#token').value);
+    $('#login').hidden = true;
+    init();
+  });
+
+  $('#refresh-button').addEventListener('click', function () {
+    request('POST', '/refresh', {}).then(function () {
+      show('Refresh requested.');
+      setTimeout(loadGallery, 3000);
+    }).catch(fail);
+  });
+
+  $('#add-active').addEventListener('click', function () {
+    $('#active-channels tbody').appendChild(activeRow('', 1));
+  });
+
+  $('#add-channel').addEventListener('click', function () {
+    $('#channel-definitions').appendChild(channelDefinition('', null));
+  });
+
+  $('#save-channels').addEventListener('click', function () {
+    try {
+      saveSettings(collectChannels());
+    } catch (e) {
+      fail(e);
+    }
+  });
+
+  $('#add-watermark').addEventListener('click', function () {
+    $('#watermark-items').appendChild(watermarkItem({
+      font: 'NotoSans-Regular.ttf',
+      point: 13,
+      color: 'ffffff',
+      position: 'bottom-right',
+      alignment: 'right',
+      template: '{{.Title}}'
+    }));
+    schedulePreview();
+  });
+
+  $('#save-watermark').addEventListener('click', function () {
+    saveSettings({ watermark: collectWatermarks() });
+  });
+
+  function init() {
+    loadStatus();
+    loadGallery();
+    loadSettings();
+  }
+
+  setInterval(loadStatus, 30000);
+  init();
+})();